@@ -0,0 +1,54 @@
+// Code generated by msf gen; DO NOT EDIT.
+
+// Package greetclient calls the operations of a micro-service
+package greetclient
+
+import (
+	"context"
+
+	"github.com/jansemmelink/msf/lib/mq"
+)
+
+// Client calls the operations through an mq client of any transport
+type Client struct {
+	c mq.IClient
+}
+
+// New client that calls operations with c, which the caller must close when done
+func New(c mq.IClient) Client {
+	return Client{c: c}
+}
+
+// GoodbyeRequest is the request of /greet/goodbye
+type GoodbyeRequest struct {
+	Name string
+}
+
+// GoodbyeResponse is the response of /greet/goodbye
+type GoodbyeResponse struct {
+	Message string
+}
+
+// Goodbye calls /greet/goodbye
+func (c Client) Goodbye(ctx context.Context, req GoodbyeRequest) (GoodbyeResponse, error) {
+	var res GoodbyeResponse
+	err := c.c.Call(ctx, "/greet/goodbye", req, &res)
+	return res, err
+}
+
+// GreeterServiceRequest is the request of /greet/greeterService
+type GreeterServiceRequest struct {
+	Name string
+}
+
+// GreeterServiceResponse is the response of /greet/greeterService
+type GreeterServiceResponse struct {
+	Message string
+}
+
+// GreeterService calls /greet/greeterService
+func (c Client) GreeterService(ctx context.Context, req GreeterServiceRequest) (GreeterServiceResponse, error) {
+	var res GreeterServiceResponse
+	err := c.c.Call(ctx, "/greet/greeterService", req, &res)
+	return res, err
+}
@@ -26,10 +26,9 @@ type greeterService struct {
 	greeting string
 
 	//Public members are request data that are specified by the user
-	//when the operation is invoked.
-
-	//todo: To define default values, specify them also during registration.
-	Name string
+	//when the operation is invoked. Tags define default values and
+	//validation rules that are applied before Validate() is called.
+	Name string `default:"anonymous" validate:"max=20"`
 }
 
 type greeterResponse struct {
@@ -44,12 +43,14 @@ func (h *greeterService) Validate() error {
 	if h.greeting == "" {
 		h.greeting = "Hello"
 	}
-	if len(h.Name) <= 0 {
-		h.Name = "anonymous"
-	}
 	return nil
 }
 
+//Doc describes the operation in service/describe and the OpenAPI specification
+func (h greeterService) Doc() string {
+	return "Greet the named person."
+}
+
 func (h greeterService) Handle() (res interface{}, a interface{}) {
 	return greeterResponse{h.greeting + " " + h.Name + "!"}, greeterAudit{Len: len(h.Name)}
 }
@@ -1,9 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/jansemmelink/msf/example/hello/greetclient"
+	"github.com/jansemmelink/msf/lib/gen"
 	"github.com/jansemmelink/msf/lib/micro"
+	"github.com/jansemmelink/msf/lib/mq/rest"
 )
 
 func Test1(t *testing.T) {
@@ -11,3 +23,106 @@ func Test1(t *testing.T) {
 	micro.Test(&greeterService{Name: "Jan"}, greeterResponse{"Hello Jan!"}, greeterAudit{Len: 3})
 	micro.Test(&greeterService{greeting: "Goodbye", Name: "Jan"}, greeterResponse{"Goodbye Jan!"}, greeterAudit{Len: 3})
 }
+
+func TestConcurrentRequests(t *testing.T) {
+	server := httptest.NewServer(rest.Router(micro.Root()))
+	defer server.Close()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("Name%d", i)
+			res, err := http.Post(server.URL+"/greet/goodbye", "application/json", strings.NewReader(`{"Name":"`+name+`"}`))
+			if err != nil {
+				t.Errorf("request %d failed: %v", i, err)
+				return
+			}
+			defer res.Body.Close()
+			response := greeterResponse{}
+			if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+				t.Errorf("request %d: invalid response: %v", i, err)
+				return
+			}
+			if response.Message != "Goodbye "+name+"!" {
+				t.Errorf("request %d: wrong response: %+v", i, response)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestValidation(t *testing.T) {
+	server := httptest.NewServer(rest.Router(micro.Root()))
+	defer server.Close()
+
+	res, err := http.Post(server.URL+"/greet/goodbye", "application/json", strings.NewReader(`{"Name":"ThisNameIsMuchTooLongToGreet"}`))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status %d != %d", res.StatusCode, http.StatusBadRequest)
+	}
+	httpErr := struct {
+		Code    micro.Code
+		Details micro.FieldErrors
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&httpErr); err != nil {
+		t.Fatalf("invalid error response: %v", err)
+	}
+	if httpErr.Code != micro.CodeInvalid || len(httpErr.Details) != 1 || httpErr.Details[0].Field != "Name" {
+		t.Fatalf("wrong error: %+v", httpErr)
+	}
+
+	//same outcome when not using HTTP
+	result := micro.Invoke(micro.Root(), micro.Request{Path: "/greet", Oper: "goodbye", Data: []byte(`{"Name":"ThisNameIsMuchTooLongToGreet"}`)})
+	if result.Err == nil || result.Err.Code != micro.CodeInvalid || result.Response != nil {
+		t.Fatalf("wrong result: %+v", result)
+	}
+}
+
+func TestGeneratedClient(t *testing.T) {
+	server := httptest.NewServer(rest.Router(micro.Root()))
+	defer server.Close()
+	restClient := rest.NewClient(server.URL)
+	defer restClient.Close()
+	c := greetclient.New(restClient)
+
+	res, err := c.Goodbye(context.Background(), greetclient.GoodbyeRequest{Name: "Jan"})
+	if err != nil || res.Message != "Goodbye Jan!" {
+		t.Fatalf("wrong result: %+v, %v", res, err)
+	}
+	_, err = c.Goodbye(context.Background(), greetclient.GoodbyeRequest{Name: "ThisNameIsMuchTooLongToGreet"})
+	if e, ok := err.(*micro.Error); !ok || e.Code != micro.CodeInvalid {
+		t.Fatalf("wrong error: %v", err)
+	}
+
+	//the committed client must match the registered operations
+	src := &bytes.Buffer{}
+	if err := gen.Client(src, micro.Root(), "/greet", "greetclient"); err != nil {
+		t.Fatalf("failed to generate: %v", err)
+	}
+	if committed, _ := ioutil.ReadFile("greetclient/client.go"); !bytes.Equal(committed, src.Bytes()) {
+		t.Fatalf("greetclient is outdated, run go generate")
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	server := httptest.NewServer(rest.Router(micro.Root()))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/service/describe?path=/greet/goodbye")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer res.Body.Close()
+	desc := micro.OperDescription{}
+	if err := json.NewDecoder(res.Body).Decode(&desc); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if desc.Doc != "Greet the named person." || desc.Request.Properties["Name"] == nil || desc.Response.Properties["Message"] == nil || desc.Audit.Properties["Len"] == nil {
+		t.Fatalf("wrong description: %+v", desc)
+	}
+}
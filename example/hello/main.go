@@ -1,16 +1,85 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/jansemmelink/msf/lib/gen"
+	"github.com/jansemmelink/msf/lib/log"
 	"github.com/jansemmelink/msf/lib/micro"
 	"github.com/jansemmelink/msf/lib/mq"
 	_ "github.com/jansemmelink/msf/lib/mq/nats"
 	_ "github.com/jansemmelink/msf/lib/mq/rabbit"
 	_ "github.com/jansemmelink/msf/lib/mq/redis"
 	_ "github.com/jansemmelink/msf/lib/mq/rest"
+	"github.com/pkg/errors"
 )
 
+//go:generate go run . -gen-client greetclient/client.go -gen-package greetclient -gen-domain /greet
+
 func main() {
 	//log.DebugOn()
 	//log.Debugf("Starting...")
-	mq.Listen(micro.Root())
+	genClient := flag.String("gen-client", "", "Write a client package for the operations to this file (- for stdout) and exit")
+	genPackage := flag.String("gen-package", "client", "Package name of the generated client")
+	genDomain := flag.String("gen-domain", "/", "Generate the client for operations in this domain")
+	openAPI := flag.String("openapi", "", "Write the OpenAPI specification of the REST API to this file (- for stdout) and exit")
+	flag.Parse()
+	if *genClient != "" || *openAPI != "" {
+		var err error
+		if *genClient != "" {
+			err = output(*genClient, func(w io.Writer) error {
+				return gen.Client(w, micro.Root(), *genDomain, *genPackage)
+			})
+		}
+		if err == nil && *openAPI != "" {
+			err = output(*openAPI, writeOpenAPI)
+		}
+		if err != nil {
+			log.Errorf("%v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	//listen until interrupted or terminated
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := mq.Listen(ctx, micro.Root())
+	stop()
+	if err != nil {
+		log.Errorf("%v", err)
+		os.Exit(1)
+	}
+}
+
+//output writes to the named file, or stdout for "-"
+func output(filename string, write func(w io.Writer) error) error {
+	if filename == "-" {
+		return write(os.Stdout)
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return errors.Wrapf(err, "cannot create %s", filename)
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+//writeOpenAPI writes the OpenAPI specification as served by the rest/openapi operation
+func writeOpenAPI(w io.Writer) error {
+	result := micro.Invoke(micro.Root(), micro.Request{Path: "/rest", Oper: "openapi"})
+	if result.Err != nil {
+		return result.Err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(result.Response)
 }
@@ -0,0 +1,61 @@
+package audit
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/jansemmelink/msf/lib/log"
+)
+
+//Event is an entry in the audit trail
+type Event struct {
+	Time     time.Time `json:"time"`
+	Listener string    `json:"listener,omitempty"`
+	Oper     string    `json:"oper"`
+	ID       string    `json:"id,omitempty"`
+	Outcome  string    `json:"outcome"`
+	Message  string    `json:"message,omitempty"`
+	Record   IRecord   `json:"record,omitempty"`
+}
+
+//Outcomes of events
+const (
+	//OutcomeExpired means the request was not processed because it expired before it was handled
+	OutcomeExpired = "expired"
+)
+
+//IWriter writes the audit trail
+type IWriter interface {
+	Write(e Event)
+}
+
+var (
+	writerMutex         = sync.Mutex{}
+	writer      IWriter = logWriter{}
+)
+
+//SetWriter replaces the default writer, which logs events
+func SetWriter(w IWriter) {
+	writerMutex.Lock()
+	defer writerMutex.Unlock()
+	writer = w
+}
+
+//Write an event to the audit trail
+func Write(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	writerMutex.Lock()
+	w := writer
+	writerMutex.Unlock()
+	w.Write(e)
+}
+
+type logWriter struct{}
+
+func (logWriter) Write(e Event) {
+	jsonEvent, _ := json.Marshal(e)
+	log.Notef("AUDIT %s", jsonEvent)
+}
@@ -4,6 +4,7 @@ import (
 	"reflect"
 
 	"github.com/jansemmelink/msf/lib/log"
+	"github.com/jansemmelink/msf/lib/schema"
 )
 
 //Describe implements IMicro to document config
@@ -18,9 +19,10 @@ func (oper Describe) Validate() error {
 
 //Schema of config
 type Schema struct {
-	Name  string `json:"name"`
-	Doc   string `json:"doc"`
-	Items []item `json:"items"`
+	Name   string         `json:"name"`
+	Doc    string         `json:"doc"`
+	Items  []item         `json:"items"`
+	Schema *schema.Schema `json:"schema,omitempty"`
 }
 
 type item struct {
@@ -38,6 +40,7 @@ func (oper Describe) Handle() (res interface{}, audit interface{}) {
 			//schema for named config
 			s.Doc = c.doc
 			s.Items = make([]item, 0)
+			s.Schema = schemaOf(c)
 
 			//list struct fields
 			t := reflect.TypeOf(c.data)
@@ -68,3 +71,35 @@ func (oper Describe) Handle() (res interface{}, audit interface{}) {
 	}
 	return s, nil
 }
+
+//Schemas implements IMicro to get the JSON Schema of config
+type Schemas struct {
+	Name string `json:"name" doc:"Name of configuration, or empty for all configuration."`
+}
+
+//Validate ...
+func (oper Schemas) Validate() error {
+	return nil
+}
+
+//Handle returns the schemas by config name
+func (oper Schemas) Handle() (res interface{}, audit interface{}) {
+	schemas := make(map[string]*schema.Schema)
+	if cs != nil {
+		for name, c := range cs.all {
+			if oper.Name == "" || oper.Name == name {
+				schemas[name] = schemaOf(c)
+			}
+		}
+	}
+	return schemas, nil
+}
+
+//schemaOf documents the config with the JSON Schema of its data
+func schemaOf(c *config) *schema.Schema {
+	s := schema.For(c.data)
+	s.Schema = schema.Draft
+	s.Description = c.doc
+	s.Nullable = false
+	return s
+}
@@ -0,0 +1,171 @@
+package gen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/jansemmelink/msf/lib/micro"
+	"github.com/jansemmelink/msf/lib/schema"
+	"github.com/pkg/errors"
+)
+
+//Client writes the Go source of a client package with one typed method per
+//operation in the domain at path ("/" for all) under root and its sub domains.
+//Each method calls the operation through an mq.IClient, so the same client
+//works over any transport. Method names are relative to the domain, e.g.
+//Goodbye() for domain "/greet". Request and response types are generated from
+//the registered types because the types of the service are usually not exported.
+func Client(w io.Writer, root micro.IDomain, path string, pkg string) error {
+	d := root
+	prefix := ""
+	for _, name := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' }) {
+		if d = d.GetSub(name); d == nil {
+			return errors.Errorf("unknown domain %s", path)
+		}
+		prefix += "/" + name
+	}
+
+	g := &generator{imports: map[string]bool{"context": true, "github.com/jansemmelink/msf/lib/mq": true}}
+	micro.Walk(d, func(sub string, d micro.IDomain) {
+		names := make([]string, 0)
+		for name := range d.Opers() {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			rel := strings.TrimSuffix(sub, "/") + "/" + name
+			g.oper(micro.ExportedName(rel), prefix+rel, d.Types(name))
+		}
+	})
+
+	src := &bytes.Buffer{}
+	fmt.Fprintf(src, "// Code generated by msf gen; DO NOT EDIT.\n\n")
+	fmt.Fprintf(src, "//Package %s calls the operations of a micro-service\n", pkg)
+	fmt.Fprintf(src, "package %s\n\nimport (\n", pkg)
+	imports := make([]string, 0, len(g.imports))
+	for imp := range g.imports {
+		imports = append(imports, imp)
+	}
+	sort.Strings(imports)
+	for i, imp := range imports {
+		if i > 0 && strings.Contains(imp, ".") && !strings.Contains(imports[i-1], ".") {
+			fmt.Fprintf(src, "\n")
+		}
+		fmt.Fprintf(src, "\t%q\n", imp)
+	}
+	fmt.Fprintf(src, ")\n\n")
+	//a named field, so that operations like "call" or "close" do not
+	//conflict with the methods of mq.IClient
+	fmt.Fprintf(src, "//Client calls the operations through an mq client of any transport\n")
+	fmt.Fprintf(src, "type Client struct {\n\tc mq.IClient\n}\n\n")
+	fmt.Fprintf(src, "//New client that calls operations with c, which the caller must close when done\n")
+	fmt.Fprintf(src, "func New(c mq.IClient) Client {\n\treturn Client{c: c}\n}\n")
+	src.Write(g.out.Bytes())
+
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		return errors.Wrapf(err, "generated invalid source")
+	}
+	_, err = w.Write(formatted)
+	return err
+}
+
+type generator struct {
+	imports map[string]bool
+	out     bytes.Buffer
+	structs map[reflect.Type]bool //being written, to stop at recursion
+}
+
+//oper writes the request and response types and the method of an operation
+func (g *generator) oper(name string, path string, types *micro.OperTypes) {
+	fmt.Fprintf(&g.out, "\n//%sRequest is the request of %s\n", name, path)
+	fmt.Fprintf(&g.out, "type %sRequest %s\n", name, g.typeExpr(types.Request))
+
+	if types.Response == nil {
+		fmt.Fprintf(&g.out, "\n//%s calls %s\n", name, path)
+		fmt.Fprintf(&g.out, "func (c Client) %s(ctx context.Context, req %sRequest) error {\n", name, name)
+		fmt.Fprintf(&g.out, "\treturn c.c.Call(ctx, %q, req, nil)\n}\n", path)
+		return
+	}
+	res := g.typeExpr(types.Response)
+	if strings.Contains(res, "struct {") {
+		fmt.Fprintf(&g.out, "\n//%sResponse is the response of %s\n", name, path)
+		fmt.Fprintf(&g.out, "type %sResponse %s\n", name, res)
+		res = name + "Response"
+	}
+	fmt.Fprintf(&g.out, "\n//%s calls %s\n", name, path)
+	fmt.Fprintf(&g.out, "func (c Client) %s(ctx context.Context, req %sRequest) (%s, error) {\n", name, name, res)
+	fmt.Fprintf(&g.out, "\tvar res %s\n", res)
+	fmt.Fprintf(&g.out, "\terr := c.c.Call(ctx, %q, req, &res)\n", path)
+	fmt.Fprintf(&g.out, "\treturn res, err\n}\n")
+}
+
+//typeExpr returns the Go expression for a type as it is encoded in JSON,
+//with custom JSON classified like the schema of the type
+func (g *generator) typeExpr(t reflect.Type) string {
+	switch {
+	case schema.IsTime(t):
+		g.imports["time"] = true
+		return "time.Time"
+	case schema.IsDuration(t):
+		g.imports["time"] = true
+		return "time.Duration"
+	case schema.IsEnum(t) || schema.IsText(t):
+		return "string"
+	case schema.IsOpaque(t) || g.structs[t]:
+		g.imports["encoding/json"] = true
+		return "json.RawMessage"
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return "*" + g.typeExpr(t.Elem())
+	case reflect.Slice:
+		return "[]" + g.typeExpr(t.Elem())
+	case reflect.Array:
+		return fmt.Sprintf("[%d]%s", t.Len(), g.typeExpr(t.Elem()))
+	case reflect.Map:
+		return fmt.Sprintf("map[%s]%s", g.typeExpr(t.Key()), g.typeExpr(t.Elem()))
+	case reflect.Interface:
+		return "interface{}"
+	case reflect.Struct:
+		if g.structs == nil {
+			g.structs = make(map[reflect.Type]bool)
+		}
+		g.structs[t] = true
+		defer delete(g.structs, t)
+		fields := &bytes.Buffer{}
+		g.fields(fields, t)
+		return "struct {\n" + fields.String() + "}"
+	}
+	//named types like level.Enum are encoded as their underlying kind
+	return t.Kind().String()
+}
+
+//fields writes the public fields of a struct, with those of embedded structs,
+//as they are encoded in JSON
+func (g *generator) fields(w io.Writer, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			g.fields(w, f.Type)
+			continue
+		}
+		if f.PkgPath != "" || f.Tag.Get("json") == "-" {
+			continue
+		}
+		tag := ""
+		if jsonTag, ok := f.Tag.Lookup("json"); ok {
+			tag = fmt.Sprintf(" `json:%q`", jsonTag)
+		}
+		comment := ""
+		if doc := f.Tag.Get("doc"); doc != "" {
+			comment = " //" + strings.Replace(doc, "\n", " ", -1)
+		}
+		fmt.Fprintf(w, "\t%s %s%s%s\n", f.Name, g.typeExpr(f.Type), tag, comment)
+	}
+}
@@ -0,0 +1,124 @@
+package gen
+
+import (
+	"bytes"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jansemmelink/msf/lib/micro"
+)
+
+type item struct {
+	Name  string            `json:"name"`
+	Tags  []string          `json:"tags,omitempty"`
+	Attrs map[string]string `json:"attrs"`
+}
+
+type create struct {
+	micro.Service
+	Items   []item        `json:"items" doc:"Items to create."`
+	When    *time.Time    `json:"when"`
+	Timeout time.Duration `json:"timeout"`
+	private string
+}
+
+type tree struct {
+	Children []tree `json:"children"`
+}
+
+type created struct {
+	IDs  []int `json:"ids"`
+	Tree tree  `json:"tree"`
+}
+
+func (c *create) Validate() error { return nil }
+
+func (c create) Handle() (interface{}, interface{}) { return created{}, nil }
+
+type ping struct {
+	micro.Service
+}
+
+func (p *ping) Validate() error { return nil }
+
+func (p ping) Handle() (interface{}, interface{}) { return "pong", nil }
+
+type reset struct {
+	ping
+}
+
+func (r reset) Handle() (interface{}, interface{}) { return nil, nil }
+
+func TestClient(t *testing.T) {
+	d := micro.Root().Sub("gentest")
+	d.Sub("item-list").AddName("create", &create{})
+	d.AddName("ping", &ping{})
+	d.AddName("reset", &reset{})
+
+	src := &bytes.Buffer{}
+	if err := Client(src, micro.Root(), "/gentest", "testclient"); err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "client.go", src.Bytes(), 0); err != nil {
+		t.Fatalf("invalid source: %v\n%s", err, src)
+	}
+	for _, expected := range []string{
+		"package testclient",
+		"\"time\"",
+		"func (c Client) ItemListCreate(ctx context.Context, req ItemListCreateRequest) (ItemListCreateResponse, error) {",
+		"err := c.c.Call(ctx, \"/gentest/item-list/create\", req, &res)",
+		"Items []struct {",
+		"Tags  []string          `json:\"tags,omitempty\"`",
+		"`json:\"items\"` //Items to create.",
+		"When    *time.Time",
+		"IDs  []int `json:\"ids\"`",
+		"Children []json.RawMessage `json:\"children\"`",
+		"func (c Client) Ping(ctx context.Context, req PingRequest) (string, error) {",
+		"func (c Client) Reset(ctx context.Context, req ResetRequest) error {",
+	} {
+		if !strings.Contains(src.String(), expected) {
+			t.Errorf("missing %s in:\n%s", expected, src)
+		}
+	}
+	if strings.Contains(src.String(), "private") {
+		t.Errorf("private field in:\n%s", src)
+	}
+	if err := Client(&bytes.Buffer{}, micro.Root(), "/unknown", "testclient"); err == nil {
+		t.Errorf("generated client for unknown domain")
+	}
+}
+
+func TestClientNames(t *testing.T) {
+	//operations named like the methods of mq.IClient
+	d := micro.Root().Sub("gennames")
+	d.AddName("call", &ping{})
+	d.AddName("close", &reset{})
+
+	src := &bytes.Buffer{}
+	if err := Client(src, micro.Root(), "/gennames", "testclient"); err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "client.go", src.Bytes(), 0)
+	if err != nil {
+		t.Fatalf("invalid source: %v\n%s", err, src)
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	if _, err := conf.Check("testclient", fset, []*ast.File{f}, nil); err != nil {
+		t.Fatalf("does not compile: %v\n%s", err, src)
+	}
+	for _, expected := range []string{
+		"func (c Client) Call(ctx context.Context, req CallRequest) (string, error) {",
+		"func (c Client) Close(ctx context.Context, req CloseRequest) error {",
+	} {
+		if !strings.Contains(src.String(), expected) {
+			t.Errorf("missing %s in:\n%s", expected, src)
+		}
+	}
+}
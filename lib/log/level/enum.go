@@ -56,6 +56,15 @@ func (e Enum) String() string {
 	return text
 }
 
+//Values lists the text of all levels, from None to Trace
+func (e Enum) Values() []string {
+	values := make([]string, 0, len(mapEnum2Text))
+	for level := None; level <= Trace; level++ {
+		values = append(values, mapEnum2Text[level])
+	}
+	return values
+}
+
 //Parse converts text into enum
 func Parse(text string) Enum {
 	if e, ok := mapText2Enum[strings.ToLower(text)]; ok {
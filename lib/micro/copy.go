@@ -0,0 +1,119 @@
+package micro
+
+import (
+	"reflect"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+//deepCopy returns a copy of v in which the public fields of structs, that
+//hold request data, share no pointers, maps or slices with v, so that the
+//copy can be decoded into without affecting the original.
+//Private fields are copied as is, so dependencies like clients, pools or
+//counters referenced from private fields are shared by all copies.
+func deepCopy(v reflect.Value) reflect.Value {
+	return copyValue(v, make(map[copiedPtr]reflect.Value))
+}
+
+//copiedPtr identifies a copied pointer by type as well as address,
+//because a struct and its first field, or zero-size values, share an address
+type copiedPtr struct {
+	t reflect.Type
+	p uintptr
+}
+
+//copyValue copies v, using copied to preserve shared and cyclic pointers
+func copyValue(v reflect.Value, copied map[copiedPtr]reflect.Value) reflect.Value {
+	c := reflect.New(v.Type()).Elem()
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return c
+		}
+		key := copiedPtr{t: v.Type(), p: v.Pointer()}
+		if existing, ok := copied[key]; ok {
+			return existing
+		}
+		p := reflect.New(v.Type().Elem())
+		copied[key] = p
+		p.Elem().Set(copyValue(v.Elem(), copied))
+		return p
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return c
+		}
+		c.Set(copyValue(v.Elem(), copied))
+		return c
+
+	case reflect.Map:
+		if v.IsNil() {
+			return c
+		}
+		c.Set(reflect.MakeMapWithSize(v.Type(), v.Len()))
+		for _, k := range v.MapKeys() {
+			c.SetMapIndex(copyValue(k, copied), copyValue(v.MapIndex(k), copied))
+		}
+		return c
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return c
+		}
+		c.Set(reflect.MakeSlice(v.Type(), v.Len(), v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(copyValue(v.Index(i), copied))
+		}
+		return c
+
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(copyValue(v.Index(i), copied))
+		}
+		return c
+
+	case reflect.Struct:
+		//copy all fields by value, then replace public reference fields
+		//with deep copies, including those promoted from embedded structs
+		c.Set(v)
+		if v.Type() == timeType {
+			return c
+		}
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			ft := t.Field(i)
+			if ft.PkgPath != "" && !(ft.Anonymous && ft.Type.Kind() == reflect.Struct) {
+				continue
+			}
+			switch ft.Type.Kind() {
+			case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Array, reflect.Struct:
+				if ft.PkgPath != "" {
+					//unexported embedded struct: copy its public fields
+					copyFields(c.Field(i), v.Field(i), copied)
+					continue
+				}
+				c.Field(i).Set(copyValue(v.Field(i), copied))
+			}
+		}
+		return c
+
+	default:
+		//values, channels and functions are copied as is
+		c.Set(v)
+		return c
+	}
+}
+
+//copyFields sets the public fields of the embedded struct c to copies of
+//those in v, as c itself cannot be set
+func copyFields(c reflect.Value, v reflect.Value, copied map[copiedPtr]reflect.Value) {
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		if c.Field(i).CanSet() {
+			c.Field(i).Set(copyValue(v.Field(i), copied))
+		} else if t.Field(i).Anonymous && t.Field(i).Type.Kind() == reflect.Struct {
+			copyFields(c.Field(i), v.Field(i), copied)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package micro
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/jansemmelink/msf/lib/log"
+)
+
+//Request is an operation invocation as received by any transport
+type Request struct {
+	//Path to the domain, e.g. "/greet" or "/billing/invoice", "" for root
+	Path string
+	//Oper is the name of the operation in that domain
+	Oper string
+	//Data is the JSON encoded request, may be empty
+	Data []byte
+	//Context is optional and is done when the request expires or is cancelled
+	Context context.Context
+	//Bind is optionally called after decoding Data and before Validate()
+	//so the transport can set request fields from its own sources, e.g. URL params
+	Bind func(oper IMicro) error
+}
+
+//Result of an operation invocation
+type Result struct {
+	Response interface{} `json:"response,omitempty"`
+	Audit    interface{} `json:"audit,omitempty"`
+	Err      *Error      `json:"error,omitempty"`
+}
+
+//Invoke the requested operation in domain d
+//It runs on a new copy of the registered operation, so the same operation
+//can be invoked concurrently. All listeners use this to process requests,
+//so that the outcome does not depend on the transport.
+func Invoke(d IDomain, req Request) (result Result) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("%s/%s panic: %v", req.Path, req.Oper, r)
+			result = Result{Err: Errorf(CodeInternal, "operation failed")}
+		}
+	}()
+
+	domain, domainErr := Resolve(d, req.Path)
+	if domainErr != nil {
+		result.Err = domainErr
+		return
+	}
+
+	oper := domain.New(req.Oper)
+	if oper == nil {
+		result.Err = Errorf(CodeNotFound, "unknown oper \"%s\", expecting %s", req.Oper, names(domain.Opers()))
+		return
+	}
+	if len(bytes.TrimSpace(req.Data)) > 0 {
+		if err := json.Unmarshal(req.Data, oper); err != nil {
+			result.Err = Errorf(CodeInvalid, "invalid request: %v", err)
+			return
+		}
+	}
+	if req.Bind != nil {
+		if err := req.Bind(oper); err != nil {
+			result.Err = Errorf(CodeInvalid, "%v", err)
+			return
+		}
+	}
+	log.Debugf("Request %s/%s: %+v", req.Path, req.Oper, oper)
+
+	if err := validate(oper); err != nil {
+		log.Debugf("Invalid %s/%s: %v", req.Path, req.Oper, err)
+		result.Err = invalid(err)
+		return
+	}
+
+	if req.Context != nil {
+		if err := req.Context.Err(); err != nil {
+			result.Err = Errorf(CodeTimeout, "request cancelled before it was handled: %v", err)
+			return
+		}
+		if s, ok := oper.(interface{ setContext(context.Context) }); ok {
+			s.setContext(req.Context)
+		}
+	}
+
+	var err error
+	result.Response, result.Audit, err = handle(oper)
+	if err != nil {
+		log.Debugf("Failed %s/%s: %v", req.Path, req.Oper, err)
+		result.Err = AsError(err)
+		return
+	}
+	log.Debugf("Response %s/%s: %+v", req.Path, req.Oper, result.Response)
+	return
+}
+
+//Resolve a domain path like "/billing/invoice" starting in domain d
+func Resolve(d IDomain, path string) (IDomain, *Error) {
+	for _, name := range strings.Split(path, "/") {
+		if name == "" {
+			continue
+		}
+		sub := d.GetSub(name)
+		if sub == nil {
+			return nil, Errorf(CodeNotFound, "unknown domain \"%s\", expecting %s", name, names(d.GetSubs()))
+		}
+		d = sub
+	}
+	return d, nil
+}
+
+//names returns sorted map keys as "a|b|c"
+func names(m interface{}) string {
+	keys := make([]string, 0)
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, "|")
+}
@@ -0,0 +1,69 @@
+package micro
+
+import (
+	"fmt"
+	"testing"
+)
+
+type failTest struct {
+	Service
+	Fail string
+}
+
+func (f *failTest) Validate() error { return nil }
+
+func (f failTest) Handle() (interface{}, interface{}) { return MustHandle(f) }
+
+func (f failTest) HandleErr() (interface{}, interface{}, error) {
+	switch f.Fail {
+	case "conflict":
+		return nil, nil, Errorf(CodeConflict, "already exists").WithDetails("x")
+	case "plain":
+		return nil, nil, fmt.Errorf("plain failure")
+	case "panic":
+		panic("oops")
+	}
+	return "ok", nil, nil
+}
+
+func TestInvokeErrors(t *testing.T) {
+	d := newDomain("")
+	d.Sub("test").AddName("fail", &failTest{})
+
+	tests := []struct {
+		path, oper, data string
+		code             Code
+	}{
+		{"/test", "fail", `{"Fail":""}`, ""},
+		{"/test", "fail", `{"Fail":"conflict"}`, CodeConflict},
+		{"/test", "fail", `{"Fail":"plain"}`, CodeInternal},
+		{"/test", "fail", `{"Fail":"panic"}`, CodeInternal},
+		{"/test", "fail", `{"Fail":`, CodeInvalid},
+		{"/test", "unknown", ``, CodeNotFound},
+		{"/unknown", "fail", ``, CodeNotFound},
+	}
+	for _, test := range tests {
+		result := Invoke(d, Request{Path: test.path, Oper: test.oper, Data: []byte(test.data)})
+		if test.code == "" {
+			if result.Err != nil || result.Response != "ok" {
+				t.Errorf("%s/%s %s: unexpected result %+v", test.path, test.oper, test.data, result)
+			}
+			continue
+		}
+		if result.Err == nil || result.Err.Code != test.code {
+			t.Errorf("%s/%s %s: expected %s, got %+v", test.path, test.oper, test.data, test.code, result.Err)
+		}
+	}
+}
+
+func TestMustHandle(t *testing.T) {
+	if res, _ := (failTest{}).Handle(); res != "ok" {
+		t.Fatalf("wrong response: %v", res)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("failure not reported")
+		}
+	}()
+	failTest{Fail: "plain"}.Handle()
+}
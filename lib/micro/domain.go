@@ -3,7 +3,10 @@ package micro
 import (
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"unicode"
 
 	"github.com/jansemmelink/msf/lib/log"
 )
@@ -18,6 +21,23 @@ type IDomain interface {
 	AddName(n string, m IMicro)
 	Get(n string) IMicro
 	Opers() map[string]IMicro
+
+	//New returns a new deep copy of the named operation as registered,
+	//with default values applied, to use for a single invocation,
+	//or nil if not registered
+	New(n string) IMicro
+
+	//Types of the named operation, or nil if not registered
+	Types(n string) *OperTypes
+}
+
+//OperTypes are the types of a registered operation
+type OperTypes struct {
+	//Request is the struct type of the operation
+	Request reflect.Type
+	//Response and Audit are nil when the operation does not return them
+	Response reflect.Type
+	Audit    reflect.Type
 }
 
 type domain struct {
@@ -91,12 +111,21 @@ func (d *domain) AddName(n string, m IMicro) {
 		panic(fmt.Sprintf("Duplicate name=\"%s\" in micro.Add(%T)", n, m))
 	}
 
+	if err := checkTags(t); err != nil {
+		panic(fmt.Sprintf("micro.Add(%s,%T): invalid tags: %v", n, m, err))
+	}
+
 	//registered pointer to struct
 	operCopy := m
-	if err := operCopy.Validate(); err != nil {
-		panic(fmt.Sprintf("micro.Add(%s,%T): invalid oper: %v", n, m, err))
+	var operResponseStruct, operAuditStruct interface{}
+	if described, ok := operCopy.(IDescribed); ok {
+		operResponseStruct, operAuditStruct = described.Describe()
+	} else {
+		if err := operCopy.Validate(); err != nil {
+			panic(fmt.Sprintf("micro.Add(%s,%T): invalid oper: %v", n, m, err))
+		}
+		operResponseStruct, operAuditStruct, _ = handle(operCopy)
 	}
-	operResponseStruct, operAuditStruct := operCopy.Handle()
 	newOper := oper{
 		req:                m,
 		responseStructType: reflect.TypeOf(operResponseStruct),
@@ -107,6 +136,8 @@ func (d *domain) AddName(n string, m IMicro) {
 }
 
 func (d *domain) Get(n string) IMicro {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
 	if existing, ok := d.oper[n]; ok {
 		return existing.req
 	}
@@ -114,6 +145,8 @@ func (d *domain) Get(n string) IMicro {
 }
 
 func (d *domain) Opers() map[string]IMicro {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
 	log.Debugf("Listing %d opers", len(d.oper))
 	opers := make(map[string]IMicro)
 	for name, oper := range d.oper {
@@ -121,3 +154,66 @@ func (d *domain) Opers() map[string]IMicro {
 	}
 	return opers
 }
+
+func (d *domain) Types(n string) *OperTypes {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	existing, ok := d.oper[n]
+	if !ok {
+		return nil
+	}
+	return &OperTypes{
+		Request:  reflect.TypeOf(existing.req).Elem(),
+		Response: existing.responseStructType,
+		Audit:    existing.auditStructType,
+	}
+}
+
+//Walk calls fn for domain d and all its sub domains, sorted by name,
+//with the path of each domain relative to d, e.g. "/billing/invoice"
+func Walk(d IDomain, fn func(path string, d IDomain)) {
+	walk("", d, fn)
+}
+
+func walk(path string, d IDomain, fn func(path string, d IDomain)) {
+	if path == "" {
+		fn("/", d)
+	} else {
+		fn(path, d)
+	}
+	subs := d.GetSubs()
+	names := make([]string, 0, len(subs))
+	for name := range subs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		walk(path+"/"+name, subs[name], fn)
+	}
+}
+
+//ExportedName makes a Go name of an operation path, used for generated
+//code and operation IDs, e.g. "/billing/invoice-item/get" -> "BillingInvoiceItemGet"
+func ExportedName(path string) string {
+	name := ""
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		runes := []rune(part)
+		name += string(unicode.ToUpper(runes[0])) + string(runes[1:])
+	}
+	if name == "" || unicode.IsDigit([]rune(name)[0]) {
+		name = "Oper" + name
+	}
+	return name
+}
+
+func (d *domain) New(n string) IMicro {
+	registered := d.Get(n)
+	if registered == nil {
+		return nil
+	}
+	oper := deepCopy(reflect.ValueOf(registered))
+	applyDefaults(oper.Elem())
+	return oper.Interface().(IMicro)
+}
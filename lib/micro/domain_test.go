@@ -0,0 +1,115 @@
+package micro
+
+import (
+	"sync"
+	"testing"
+)
+
+type copyTest struct {
+	Service
+	copyEmbedded
+	greeting string
+	counter  *copyCounter
+	Name     string
+	Tags     []string
+	Attrs    map[string]int
+	Address  *copyAddress
+}
+
+type copyCounter struct {
+	mutex sync.Mutex
+	count int
+}
+
+type copyEmbedded struct {
+	Items []string
+}
+
+type copyAddress struct {
+	City string
+}
+
+func (c *copyTest) Validate() error { return nil }
+
+func (c copyTest) Handle() (interface{}, interface{}) {
+	c.counter.mutex.Lock()
+	defer c.counter.mutex.Unlock()
+	c.counter.count++
+	return nil, nil
+}
+
+func TestNewCopiesRequestFields(t *testing.T) {
+	d := newDomain("test")
+	d.AddName("copy", &copyTest{
+		greeting:     "Hi",
+		counter:      &copyCounter{},
+		Tags:         []string{"x"},
+		Attrs:        map[string]int{"a": 1},
+		Address:      &copyAddress{City: "here"},
+		copyEmbedded: copyEmbedded{Items: []string{"i"}},
+	})
+
+	c1 := d.New("copy").(*copyTest)
+	c2 := d.New("copy").(*copyTest)
+	if c1 == c2 || c1.Address == c2.Address {
+		t.Fatalf("New() returned shared values")
+	}
+	c1.Name = "one"
+	c1.greeting = "Bye"
+	c1.Tags[0] = "y"
+	c1.Attrs["a"] = 2
+	c1.Address.City = "there"
+	c1.Items[0] = "j"
+	if c2.Items[0] != "i" || c2.Name != "" || c2.greeting != "Hi" || c2.Tags[0] != "x" || c2.Attrs["a"] != 1 || c2.Address.City != "here" {
+		t.Fatalf("copy modified by another copy: %+v", c2)
+	}
+	registered := d.Get("copy").(*copyTest)
+	if registered.greeting != "Hi" || registered.Tags[0] != "x" || registered.Address.City != "here" {
+		t.Fatalf("registered oper modified: %+v", registered)
+	}
+	if d.New("unknown") != nil {
+		t.Fatalf("New(unknown) != nil")
+	}
+
+	//dependencies in private fields are shared by all requests
+	if c1.counter != registered.counter || c2.counter != registered.counter {
+		t.Fatalf("private pointer copied")
+	}
+	registered.counter.count = 0
+	for i := 0; i < 5; i++ {
+		if result := Invoke(d, Request{Oper: "copy"}); result.Err != nil {
+			t.Fatalf("invoke failed: %v", result.Err)
+		}
+	}
+	if registered.counter.count != 5 {
+		t.Fatalf("shared counter = %d != 5", registered.counter.count)
+	}
+}
+
+type copyInner struct {
+	X int `json:"x"`
+}
+
+//copyAlias has pointers of different types with the same address
+type copyAlias struct {
+	Service
+	A *copyInner `json:"a"`
+	B *int       `json:"b"`
+}
+
+func (c *copyAlias) Validate() error { return nil }
+
+func (c copyAlias) Handle() (interface{}, interface{}) { return nil, nil }
+
+func TestNewCopiesAliasedPointers(t *testing.T) {
+	d := newDomain("test")
+	in := &copyInner{X: 1}
+	d.AddName("alias", &copyAlias{A: in, B: &in.X})
+	c := d.New("alias").(*copyAlias)
+	if c.A == in || c.A.X != 1 || *c.B != 1 {
+		t.Fatalf("wrong copy: %+v", c)
+	}
+	if result := Invoke(d, Request{Oper: "alias"}); result.Err != nil {
+		t.Fatalf("invoke failed: %v", result.Err)
+	}
+}
@@ -0,0 +1,114 @@
+package micro
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+//Code classifies an Error independent of the transport
+type Code string
+
+//Error codes
+const (
+	//CodeInvalid means the request could not be decoded or is not valid
+	CodeInvalid Code = "invalid"
+	//CodeUnauthorized means the caller is not authenticated
+	CodeUnauthorized Code = "unauthorized"
+	//CodeForbidden means the caller may not do this
+	CodeForbidden Code = "forbidden"
+	//CodeNotFound means the domain, operation or requested item does not exist
+	CodeNotFound Code = "not_found"
+	//CodeConflict means the request conflicts with the current state
+	CodeConflict Code = "conflict"
+	//CodeUnavailable means the operation cannot be done now, but may succeed later
+	CodeUnavailable Code = "unavailable"
+	//CodeTimeout means the request expired or was cancelled before it completed
+	CodeTimeout Code = "timeout"
+	//CodeInternal means the operation failed unexpectedly
+	CodeInternal Code = "internal"
+)
+
+//Error is a structured error returned from an operation invocation
+type Error struct {
+	Code    Code        `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+//Errorf creates a new error with the specified code
+func Errorf(code Code, f string, a ...interface{}) *Error {
+	return &Error{
+		Code:    code,
+		Message: fmt.Sprintf(f, a...),
+	}
+}
+
+//AsError returns err as *Error, or wraps other errors with CodeInternal
+func AsError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := errors.Cause(err).(*Error); ok {
+		return e
+	}
+	return &Error{Code: CodeInternal, Message: err.Error()}
+}
+
+//invalid returns err from Validate() as a CodeInvalid error
+func invalid(err error) *Error {
+	switch e := errors.Cause(err).(type) {
+	case *Error:
+		return e
+	case FieldErrors:
+		return Errorf(CodeInvalid, "invalid request: %v", e).WithDetails(e)
+	}
+	return Errorf(CodeInvalid, "%v", err)
+}
+
+//WithDetails sets details of the error
+func (e *Error) WithDetails(details interface{}) *Error {
+	e.Details = details
+	return e
+}
+
+//Error implements the error interface
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+//FieldError describes a problem with one request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+//FieldErrors lists all problems found when validating a request
+//Return it from Validate() to report the problems to the client
+//in the details of a CodeInvalid error.
+type FieldErrors []FieldError
+
+//Add a problem with the named field
+func (fe *FieldErrors) Add(field string, f string, a ...interface{}) {
+	*fe = append(*fe, FieldError{Field: field, Message: fmt.Sprintf(f, a...)})
+}
+
+//Err returns nil when there are no problems, else the list as an error
+func (fe FieldErrors) Err() error {
+	if len(fe) == 0 {
+		return nil
+	}
+	return fe
+}
+
+//Error implements the error interface
+func (fe FieldErrors) Error() string {
+	s := ""
+	for _, e := range fe {
+		s += fmt.Sprintf(", %s: %s", e.Field, e.Message)
+	}
+	if len(s) > 0 {
+		s = s[2:]
+	}
+	return s
+}
@@ -0,0 +1,178 @@
+package micro
+
+import (
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+)
+
+//IDoc may be implemented by operations to describe what they do
+type IDoc interface {
+	Doc() string
+}
+
+//Version of the program, which may be set when building, e.g.
+//
+//	go build -ldflags "-X github.com/jansemmelink/msf/lib/micro.Version=1.2.3"
+//
+//else the module version from the build info is reported.
+var Version string
+
+var started = time.Now()
+
+//DomainInfo lists the operations and sub domains of a domain
+type DomainInfo struct {
+	Path    string       `json:"path"`
+	Opers   []string     `json:"opers"`
+	Domains []DomainInfo `json:"domains"`
+}
+
+//Tree of domain d at path
+func Tree(d IDomain, path string) DomainInfo {
+	info := DomainInfo{Path: path, Opers: make([]string, 0), Domains: make([]DomainInfo, 0)}
+	for name := range d.Opers() {
+		info.Opers = append(info.Opers, name)
+	}
+	sort.Strings(info.Opers)
+	subs := d.GetSubs()
+	names := make([]string, 0, len(subs))
+	for name := range subs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		info.Domains = append(info.Domains, Tree(subs[name], strings.TrimSuffix(path, "/")+"/"+name))
+	}
+	return info
+}
+
+//OperDescription documents an operation
+type OperDescription struct {
+	OperSchema
+	Doc string `json:"doc,omitempty"`
+}
+
+//Describe the operation at path in domain d, e.g. "/greet/goodbye"
+func Describe(d IDomain, path string) (OperDescription, *Error) {
+	s, err := SchemaOf(d, path)
+	if err != nil {
+		return OperDescription{}, err
+	}
+	return describe(d, s), nil
+}
+
+//describe adds the doc of the operation to its schema
+func describe(d IDomain, s OperSchema) OperDescription {
+	desc := OperDescription{OperSchema: s}
+	//the operation is known to exist
+	domainPath := s.Path[:strings.LastIndex(s.Path, "/")]
+	operDomain, _ := Resolve(d, domainPath)
+	if doc, ok := operDomain.Get(s.Path[len(domainPath)+1:]).(IDoc); ok {
+		desc.Doc = doc.Doc()
+	}
+	return desc
+}
+
+//BuildInfo describes the running program
+type BuildInfo struct {
+	Program      string            `json:"program"`
+	Path         string            `json:"path,omitempty"`
+	Version      string            `json:"version"`
+	GoVersion    string            `json:"goVersion,omitempty"`
+	Revision     string            `json:"revision,omitempty"`
+	RevisionTime string            `json:"revisionTime,omitempty"`
+	Modified     bool              `json:"modified,omitempty"`
+	Started      time.Time         `json:"started"`
+	Dependencies map[string]string `json:"dependencies,omitempty"`
+}
+
+//Build info of the running program
+func Build() BuildInfo {
+	b := BuildInfo{Program: filepath.Base(os.Args[0]), Version: Version, Started: started}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		if b.Version == "" {
+			b.Version = "unknown"
+		}
+		return b
+	}
+	b.Path = info.Main.Path
+	if b.Version == "" {
+		b.Version = info.Main.Version
+	}
+	b.GoVersion = info.GoVersion
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			b.Revision = setting.Value
+		case "vcs.time":
+			b.RevisionTime = setting.Value
+		case "vcs.modified":
+			b.Modified = setting.Value == "true"
+		}
+	}
+	if len(info.Deps) > 0 {
+		b.Dependencies = make(map[string]string, len(info.Deps))
+		for _, dep := range info.Deps {
+			b.Dependencies[dep.Path] = dep.Version
+		}
+	}
+	return b
+}
+
+//treeOper lists the domains and operations of the service
+type treeOper struct {
+	Service
+	Path string `json:"path" default:"/" doc:"Path of the domain to list, e.g. /greet. Defaults to all domains."`
+}
+
+func (oper *treeOper) Validate() error { return nil }
+
+func (oper treeOper) Doc() string { return "List the domains and operations of the service." }
+
+func (oper treeOper) Describe() (interface{}, interface{}) { return DomainInfo{}, nil }
+
+func (oper treeOper) Handle() (interface{}, interface{}) { return MustHandle(oper) }
+
+func (oper treeOper) HandleErr() (interface{}, interface{}, error) {
+	d, err := Resolve(rootDomain, oper.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return Tree(d, "/"+strings.Trim(oper.Path, "/")), nil, nil
+}
+
+//describeOper documents an operation, using /schema/oper for its schemas
+type describeOper struct {
+	operSchema
+}
+
+func (oper describeOper) Doc() string {
+	return "Describe the request, response and audit record of an operation."
+}
+
+func (oper describeOper) Describe() (interface{}, interface{}) { return OperDescription{}, nil }
+
+func (oper describeOper) Handle() (interface{}, interface{}) { return MustHandle(oper) }
+
+func (oper describeOper) HandleErr() (interface{}, interface{}, error) {
+	s, _, err := oper.operSchema.HandleErr()
+	if err != nil {
+		return nil, nil, err
+	}
+	return describe(rootDomain, s.(OperSchema)), nil, nil
+}
+
+//versionOper reports the version and build info of the program
+type versionOper struct {
+	Service
+}
+
+func (oper *versionOper) Validate() error { return nil }
+
+func (oper versionOper) Doc() string { return "Report the version and build info of the program." }
+
+func (oper versionOper) Handle() (interface{}, interface{}) { return Build(), nil }
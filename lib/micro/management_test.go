@@ -0,0 +1,44 @@
+package micro
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestManagement(t *testing.T) {
+	result := Invoke(Root(), Request{Path: "/service", Oper: "tree", Data: []byte(`{}`)})
+	tree, ok := result.Response.(DomainInfo)
+	if result.Err != nil || !ok || tree.Path != "/" {
+		t.Fatalf("wrong result: %+v", result)
+	}
+	var service *DomainInfo
+	for i, d := range tree.Domains {
+		if d.Path == "/service" {
+			service = &tree.Domains[i]
+		}
+	}
+	if service == nil || strings.Join(service.Opers, ",") != "describe,tree,version" {
+		t.Fatalf("wrong tree: %+v", tree)
+	}
+	result = Invoke(Root(), Request{Path: "/service", Oper: "tree", Data: []byte(`{"path":"/unknown"}`)})
+	if result.Err == nil || result.Err.Code != CodeNotFound {
+		t.Fatalf("wrong error: %+v", result.Err)
+	}
+
+	result = Invoke(Root(), Request{Path: "/service", Oper: "describe", Data: []byte(`{"path":"/service/version"}`)})
+	desc, ok := result.Response.(OperDescription)
+	if result.Err != nil || !ok || desc.Doc == "" || desc.Path != "/service/version" || desc.Response.Properties["goVersion"] == nil {
+		t.Fatalf("wrong description: %+v", result)
+	}
+	result = Invoke(Root(), Request{Path: "/service", Oper: "describe", Data: []byte(`{}`)})
+	if result.Err == nil || result.Err.Code != CodeInvalid {
+		t.Fatalf("described without path: %+v", result)
+	}
+
+	Version = "1.2.3"
+	defer func() { Version = "" }()
+	result = Invoke(Root(), Request{Path: "/service", Oper: "version"})
+	if build, ok := result.Response.(BuildInfo); !ok || build.Version != "1.2.3" || build.Started.IsZero() {
+		t.Fatalf("wrong build info: %+v", result)
+	}
+}
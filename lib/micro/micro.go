@@ -1,7 +1,9 @@
 package micro
 
 import (
+	"context"
 	"fmt"
+	"reflect"
 
 	"github.com/jansemmelink/msf/lib/audit"
 	"github.com/jansemmelink/msf/lib/config"
@@ -16,8 +18,60 @@ type IMicro interface {
 	Handle() (response interface{}, audit interface{})
 }
 
-//Service ...
+//IErrorHandler may be implemented by operations that may fail,
+//then HandleErr() is called instead of Handle().
+//Return an *Error to control the error code reported to the client,
+//any other error is reported as CodeInternal.
+//Such operations implement Handle() with MustHandle(), e.g.:
+//
+//	func (o oper) Handle() (interface{}, interface{}) { return micro.MustHandle(o) }
+type IErrorHandler interface {
+	//HandleErr handles the request to product a response and audit record, or an error
+	HandleErr() (response interface{}, audit interface{}, err error)
+}
+
+//MustHandle calls HandleErr() and panics if it fails, so that calling
+//Handle() directly on an operation that failed does not go unnoticed
+func MustHandle(h IErrorHandler) (response interface{}, audit interface{}) {
+	response, audit, err := h.HandleErr()
+	if err != nil {
+		panic(fmt.Sprintf("%T.HandleErr() failed: %v", h, err))
+	}
+	return response, audit
+}
+
+//IDescribed may be implemented by operations that must not be validated
+//and handled when they are registered, e.g. operations with side effects,
+//to return examples of their response and audit types instead.
+type IDescribed interface {
+	Describe() (response interface{}, audit interface{})
+}
+
+//handle calls HandleErr() if implemented, else Handle()
+func handle(m IMicro) (response interface{}, audit interface{}, err error) {
+	if h, ok := m.(IErrorHandler); ok {
+		return h.HandleErr()
+	}
+	response, audit = m.Handle()
+	return response, audit, nil
+}
+
+//Service should be embedded in operation structs
 type Service struct {
+	ctx context.Context
+}
+
+//Context of the request being handled, which is done when the request
+//expires or is cancelled by the transport
+func (s Service) Context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+func (s *Service) setContext(ctx context.Context) {
+	s.ctx = ctx
 }
 
 var (
@@ -30,6 +84,13 @@ func init() {
 	//add some management operations
 	mgt := rootDomain.Sub("config")
 	mgt.AddName("describe", &config.Describe{})
+	schemas := rootDomain.Sub("schema")
+	schemas.AddName("config", &config.Schemas{})
+	schemas.AddName("oper", &operSchema{})
+	service := rootDomain.Sub("service")
+	service.AddName("tree", &treeOper{})
+	service.AddName("describe", &describeOper{})
+	service.AddName("version", &versionOper{})
 }
 
 //Root domain
@@ -44,10 +105,16 @@ func Domain(n string) IDomain {
 
 //Test ...
 func Test(req IMicro, expectedResponse interface{}, expectedAudit audit.IRecord) {
-	if err := req.Validate(); err != nil {
+	if v := reflect.ValueOf(req); v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Struct {
+		applyDefaults(v.Elem())
+	}
+	if err := validate(req); err != nil {
 		panic(fmt.Sprintf("Validation failed: %v", err))
 	}
-	res, ar := req.Handle()
+	res, ar, err := handle(req)
+	if err != nil {
+		panic(fmt.Sprintf("Handle failed: %v", err))
+	}
 	if res != expectedResponse {
 		panic(fmt.Sprintf("Wrong response: %+v != %+v", res, expectedResponse))
 	}
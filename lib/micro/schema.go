@@ -0,0 +1,67 @@
+package micro
+
+import (
+	"strings"
+
+	"github.com/jansemmelink/msf/lib/schema"
+)
+
+//OperSchema has the JSON Schemas of an operation
+type OperSchema struct {
+	Path     string         `json:"path"`
+	Request  *schema.Schema `json:"request"`
+	Response *schema.Schema `json:"response,omitempty"`
+	Audit    *schema.Schema `json:"audit,omitempty"`
+}
+
+//SchemaOf the operation at path in domain d, e.g. "/greet/goodbye"
+func SchemaOf(d IDomain, path string) (OperSchema, *Error) {
+	domainPath, name := "", strings.Trim(path, "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		domainPath, name = name[:i], name[i+1:]
+	}
+	d, err := Resolve(d, domainPath)
+	if err != nil {
+		return OperSchema{}, err
+	}
+	types := d.Types(name)
+	if types == nil {
+		return OperSchema{}, Errorf(CodeNotFound, "unknown operation \"%s\", expecting %s", name, names(d.Opers()))
+	}
+	s := OperSchema{
+		Path:     "/" + strings.Trim(path, "/"),
+		Request:  schema.Of(types.Request),
+		Response: schema.Of(types.Response),
+		Audit:    schema.Of(types.Audit),
+	}
+	for _, js := range []*schema.Schema{s.Request, s.Response, s.Audit} {
+		if js != nil {
+			js.Schema = schema.Draft
+		}
+	}
+	return s, nil
+}
+
+//operSchema implements IMicro to get the schemas of an operation
+type operSchema struct {
+	Service
+	Path string `json:"path" validate:"required" doc:"Path of the operation, e.g. /greet/goodbye."`
+}
+
+func (oper *operSchema) Validate() error { return nil }
+
+func (oper operSchema) Doc() string {
+	return "Get the JSON Schemas of the request, response and audit record of an operation."
+}
+
+func (oper operSchema) Describe() (interface{}, interface{}) { return OperSchema{}, nil }
+
+func (oper operSchema) Handle() (interface{}, interface{}) { return MustHandle(oper) }
+
+func (oper operSchema) HandleErr() (interface{}, interface{}, error) {
+	s, err := SchemaOf(rootDomain, oper.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, nil, nil
+}
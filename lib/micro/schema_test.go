@@ -0,0 +1,27 @@
+package micro
+
+import (
+	"testing"
+
+	"github.com/jansemmelink/msf/lib/schema"
+)
+
+func TestSchemaOf(t *testing.T) {
+	result := Invoke(Root(), Request{Path: "/schema", Oper: "oper", Data: []byte(`{"path":"/schema/oper"}`)})
+	s, ok := result.Response.(OperSchema)
+	if result.Err != nil || !ok {
+		t.Fatalf("wrong result: %+v", result)
+	}
+	if s.Path != "/schema/oper" || s.Request.Properties["path"].Description == "" || s.Response.Properties["request"] == nil || s.Audit != nil {
+		t.Fatalf("wrong schema: %+v", s)
+	}
+	if _, err := SchemaOf(Root(), "/schema/unknown"); err == nil || err.Code != CodeNotFound {
+		t.Fatalf("wrong error: %v", err)
+	}
+
+	result = Invoke(Root(), Request{Path: "/schema", Oper: "config", Data: []byte(`{"name":"log"}`)})
+	configs, ok := result.Response.(map[string]*schema.Schema)
+	if result.Err != nil || !ok || len(configs) != 1 || len(configs["log"].Properties["global"].Enum) == 0 {
+		t.Fatalf("wrong config schemas: %+v", result)
+	}
+}
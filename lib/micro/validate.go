@@ -0,0 +1,334 @@
+package micro
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+//Public request fields may be validated with struct tags, e.g.:
+//	Name  string `json:"name" validate:"required,min=1,max=20" default:"anonymous"`
+//	Code  string `json:"code" validate:"len=3" pattern:"^[A-Z]+$"`
+//	Color string `json:"color" validate:"oneof=red|green|blue"`
+//	Count int    `json:"count" validate:"min=1,max=100" default:"10"`
+//The default value is set in a new request before it is decoded, when the
+//field of the registered operation has a zero value, so that a request can
+//still set the field to a zero value, e.g. false or 0.
+//	required: field may not have a zero value
+//	min/max:  minimum/maximum value of numbers or length of strings, slices and maps
+//	len:      exact length of strings, slices and maps
+//	oneof:    "|" separated list of allowed values
+//	pattern:  regular expression that string values must match
+//Other rules only apply when a field has a non-zero value.
+//Tags are checked before the operation's own Validate() is called,
+//and all problems are reported at once.
+
+//validate applies validation tags then calls the operation's own Validate()
+func validate(m IMicro) error {
+	v := reflect.ValueOf(m)
+	if v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Struct {
+		errs := FieldErrors{}
+		validateStruct(v.Elem(), "", &errs)
+		if len(errs) > 0 {
+			return errs
+		}
+	}
+	return m.Validate()
+}
+
+//applyDefaults sets the default values of public fields that are zero,
+//including those of nested structs
+func applyDefaults(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		ft := t.Field(i)
+		fv := v.Field(i)
+		if ft.Anonymous {
+			if ft.Type.Kind() == reflect.Struct {
+				applyDefaults(fv)
+			}
+			continue
+		}
+		if fieldName(ft) == "" {
+			continue
+		}
+		if def, ok := ft.Tag.Lookup("default"); ok && fv.IsZero() {
+			//the default value was checked by checkTags()
+			setString(fv, def)
+		}
+		if fv.Kind() == reflect.Struct && fv.Type() != timeType {
+			applyDefaults(fv)
+		}
+	}
+}
+
+//validateStruct checks the tags of each public field
+func validateStruct(v reflect.Value, prefix string, errs *FieldErrors) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		ft := t.Field(i)
+		if ft.Anonymous {
+			if ft.Type.Kind() == reflect.Struct {
+				validateStruct(v.Field(i), prefix, errs)
+			}
+			continue
+		}
+		name := fieldName(ft)
+		if name == "" {
+			continue
+		}
+		validateField(v.Field(i), ft, prefix+name, errs)
+	}
+}
+
+func validateField(fv reflect.Value, ft reflect.StructField, name string, errs *FieldErrors) {
+	rules := parseRules(ft.Tag.Get("validate"))
+	if fv.IsZero() {
+		if _, ok := rules["required"]; ok {
+			errs.Add(name, "is required")
+		}
+		//nested struct values are always present, so check their own fields
+		if fv.Kind() == reflect.Struct && fv.Type() != timeType {
+			validateStruct(fv, name+".", errs)
+		}
+		return
+	}
+	if fv.Kind() == reflect.Ptr {
+		fv = fv.Elem()
+	}
+
+	if value, ok := rules["min"]; ok {
+		if size, isLen := measure(fv); size < parseFloat(value) {
+			errs.Add(name, "%s must be at least %s", measureName(isLen), value)
+		}
+	}
+	if value, ok := rules["max"]; ok {
+		if size, isLen := measure(fv); size > parseFloat(value) {
+			errs.Add(name, "%s must be at most %s", measureName(isLen), value)
+		}
+	}
+	if value, ok := rules["len"]; ok {
+		if size, _ := measure(fv); size != parseFloat(value) {
+			errs.Add(name, "length must be %s", value)
+		}
+	}
+	if value, ok := rules["oneof"]; ok {
+		found := false
+		for _, option := range strings.Split(value, "|") {
+			if fmt.Sprint(fv.Interface()) == option {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs.Add(name, "must be one of %s", value)
+		}
+	}
+	if pattern, ok := ft.Tag.Lookup("pattern"); ok && fv.Kind() == reflect.String {
+		if re, err := compile(pattern); err != nil || !re.MatchString(fv.String()) {
+			errs.Add(name, "must match %s", pattern)
+		}
+	}
+
+	//validate nested structs
+	switch {
+	case fv.Kind() == reflect.Struct && fv.Type() != timeType:
+		validateStruct(fv, name+".", errs)
+	case fv.Kind() == reflect.Slice || fv.Kind() == reflect.Array:
+		for i := 0; i < fv.Len(); i++ {
+			ev := fv.Index(i)
+			if ev.Kind() == reflect.Ptr && !ev.IsNil() {
+				ev = ev.Elem()
+			}
+			if ev.Kind() == reflect.Struct && ev.Type() != timeType {
+				validateStruct(ev, fmt.Sprintf("%s[%d].", name, i), errs)
+			}
+		}
+	}
+}
+
+//checkTags checks that the validation tags of struct type t can be applied,
+//so that mistakes are found when an operation is registered
+func checkTags(t reflect.Type) error {
+	for i := 0; i < t.NumField(); i++ {
+		ft := t.Field(i)
+		if ft.Anonymous {
+			if ft.Type.Kind() == reflect.Struct {
+				if err := checkTags(ft.Type); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if fieldName(ft) == "" {
+			continue
+		}
+		for rule, value := range parseRules(ft.Tag.Get("validate")) {
+			switch rule {
+			case "required", "oneof":
+			case "min", "max", "len":
+				if _, err := strconv.ParseFloat(value, 64); err != nil {
+					return fmt.Errorf("field %s: %s=%s is not a number", ft.Name, rule, value)
+				}
+			default:
+				return fmt.Errorf("field %s: unknown validation rule \"%s\"", ft.Name, rule)
+			}
+		}
+		if pattern, ok := ft.Tag.Lookup("pattern"); ok {
+			if _, err := compile(pattern); err != nil {
+				return fmt.Errorf("field %s: invalid pattern: %v", ft.Name, err)
+			}
+		}
+		if def, ok := ft.Tag.Lookup("default"); ok {
+			if err := setString(reflect.New(ft.Type).Elem(), def); err != nil {
+				return fmt.Errorf("field %s: invalid default: %v", ft.Name, err)
+			}
+		}
+		nested := ft.Type
+		for nested.Kind() == reflect.Ptr || nested.Kind() == reflect.Slice || nested.Kind() == reflect.Array {
+			nested = nested.Elem()
+		}
+		if nested.Kind() == reflect.Struct && nested != timeType {
+			if err := checkTags(nested); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//fieldName returns the JSON name of a public field, or "" to skip it
+func fieldName(ft reflect.StructField) string {
+	if ft.PkgPath != "" {
+		return ""
+	}
+	name := strings.Split(ft.Tag.Get("json"), ",")[0]
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return ft.Name
+	}
+	return name
+}
+
+//parseRules parses a tag like "required,min=1,max=10"
+func parseRules(tag string) map[string]string {
+	rules := make(map[string]string)
+	for _, rule := range strings.Split(tag, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		nv := strings.SplitN(rule, "=", 2)
+		if len(nv) == 2 {
+			rules[nv[0]] = nv[1]
+		} else {
+			rules[nv[0]] = ""
+		}
+	}
+	return rules
+}
+
+//measure returns the value of numbers, or the length of other values
+func measure(v reflect.Value) (size float64, isLen bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), false
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), false
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), false
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), true
+	}
+	return 0, false
+}
+
+func measureName(isLen bool) string {
+	if isLen {
+		return "length"
+	}
+	return "value"
+}
+
+func parseFloat(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+//setString sets v to the value parsed from s
+func setString(v reflect.Value, s string) error {
+	if v.Kind() == reflect.Ptr {
+		p := reflect.New(v.Type().Elem())
+		if err := setString(p.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	}
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("cannot set %v from \"%s\"", v.Type(), s)
+	}
+	return nil
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	patterns     = sync.Map{}
+)
+
+//compile a regular expression once
+func compile(pattern string) (*regexp.Regexp, error) {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	patterns.Store(pattern, re)
+	return re, nil
+}
@@ -0,0 +1,119 @@
+package micro
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type tagTest struct {
+	Service
+	Name    string        `json:"name" validate:"required,max=5"`
+	Code    string        `json:"code" validate:"len=3" pattern:"^[A-Z]+$"`
+	Color   string        `json:"color" validate:"oneof=red|green" default:"red"`
+	Count   int           `json:"count" validate:"min=1,max=10" default:"5"`
+	Timeout time.Duration `json:"timeout" default:"1s"`
+	Items   []tagItem     `json:"items" validate:"max=2"`
+	Address tagAddress    `json:"address"`
+}
+
+type tagAddress struct {
+	Street string `json:"street" validate:"required"`
+	City   string `json:"city" default:"here"`
+}
+
+type tagItem struct {
+	ID string `json:"id" validate:"required"`
+}
+
+func (t *tagTest) Validate() error { return nil }
+
+func (t tagTest) Handle() (interface{}, interface{}) { return t, nil }
+
+func TestValidateTags(t *testing.T) {
+	if err := checkTags(reflectType(&tagTest{})); err != nil {
+		t.Fatalf("checkTags failed: %v", err)
+	}
+
+	req := &tagTest{Name: "Jan", Address: tagAddress{Street: "Main"}}
+	applyDefaults(reflect.ValueOf(req).Elem())
+	if err := validate(req); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+	if req.Color != "red" || req.Count != 5 || req.Timeout != time.Second || req.Address.City != "here" {
+		t.Fatalf("defaults not applied: %+v", req)
+	}
+
+	req = &tagTest{Code: "ab", Color: "blue", Count: 11, Items: []tagItem{{ID: "1"}, {}, {}}}
+	err := validate(req)
+	errs, ok := err.(FieldErrors)
+	if !ok {
+		t.Fatalf("expected FieldErrors, got %T: %v", err, err)
+	}
+	expected := []string{"name", "code", "code", "color", "count", "items", "items[1].id", "items[2].id", "address.street"}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %d: %v", len(expected), len(errs), errs)
+	}
+	for i, field := range expected {
+		if errs[i].Field != field {
+			t.Errorf("error[%d] on %s, expected %s: %v", i, errs[i].Field, field, errs[i].Message)
+		}
+	}
+
+	//an empty nested struct is checked like a missing one
+	for _, data := range []string{`{"name":"Jan"}`, `{"name":"Jan","address":{}}`} {
+		req = &tagTest{}
+		if err := json.Unmarshal([]byte(data), req); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+		errs, ok := validate(req).(FieldErrors)
+		if !ok || len(errs) != 1 || errs[0].Field != "address.street" {
+			t.Fatalf("%s: wrong errors: %v", data, errs)
+		}
+	}
+}
+
+type defaultTest struct {
+	Service
+	Enabled bool `json:"enabled" default:"true"`
+	Count   int  `json:"count" default:"10"`
+}
+
+func (t *defaultTest) Validate() error { return nil }
+
+func (t defaultTest) Handle() (interface{}, interface{}) { return t, nil }
+
+func TestDefaults(t *testing.T) {
+	Root().Sub("defaulttest").AddName("get", &defaultTest{})
+	for data, expected := range map[string]defaultTest{
+		`{}`:                          {Enabled: true, Count: 10},
+		`{"enabled":false,"count":0}`: {Enabled: false, Count: 0},
+		`{"enabled":true,"count":3}`:  {Enabled: true, Count: 3},
+	} {
+		result := Invoke(Root(), Request{Path: "/defaulttest", Oper: "get", Data: []byte(data)})
+		res, ok := result.Response.(defaultTest)
+		if result.Err != nil || !ok || res.Enabled != expected.Enabled || res.Count != expected.Count {
+			t.Errorf("%s: wrong result %+v", data, result)
+		}
+	}
+}
+
+type badTagTest struct {
+	Service
+	Name string `validate:"maximum=5"`
+}
+
+func (t *badTagTest) Validate() error { return nil }
+
+func (t badTagTest) Handle() (interface{}, interface{}) { return nil, nil }
+
+func TestCheckTags(t *testing.T) {
+	if err := checkTags(reflectType(&badTagTest{})); err == nil {
+		t.Fatalf("expected invalid tag")
+	}
+}
+
+func reflectType(m IMicro) reflect.Type {
+	return reflect.TypeOf(m).Elem()
+}
@@ -0,0 +1,48 @@
+package mq
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+//Backoff computes exponentially increasing delays with random jitter
+//between attempts, e.g. to reconnect after a connection was lost,
+//so that many clients do not all retry at the same moment.
+type Backoff struct {
+	Min time.Duration
+	Max time.Duration
+
+	attempt uint
+}
+
+//Next returns the delay before the next attempt,
+//which doubles after each attempt up to Max,
+//with a random jitter of up to half the delay
+func (b *Backoff) Next() time.Duration {
+	d := b.Max
+	if b.attempt < 32 && b.Min<<b.attempt < b.Max {
+		d = b.Min << b.attempt
+	}
+	b.attempt++
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+//Reset after a successful attempt
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
+
+//Wait for the next delay, or until ctx is done,
+//returns false when ctx is done
+func (b *Backoff) Wait(ctx context.Context) bool {
+	select {
+	case <-time.After(b.Next()):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,20 @@
+package mq
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := Backoff{Min: 100 * time.Millisecond, Max: time.Second}
+	for i, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		max *= time.Millisecond
+		if d := b.Next(); d < max/2 || d > max {
+			t.Errorf("attempt %d: delay %v not in %v..%v", i, d, max/2, max)
+		}
+	}
+	b.Reset()
+	if d := b.Next(); d > 100*time.Millisecond {
+		t.Errorf("delay %v after reset", d)
+	}
+}
@@ -0,0 +1,76 @@
+package mq
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jansemmelink/msf/lib/micro"
+)
+
+//IClient invokes operations of other services over a message queue
+//Each transport has its own implementation, created by its NewClient().
+type IClient interface {
+	//Call the operation at path, e.g. "/greet/goodbye", with the request,
+	//and decode the response into res, which may be nil to ignore it.
+	//When ctx has no deadline, the call times out after DefaultTimeout.
+	//Errors are *micro.Error, with the code returned by the operation,
+	//CodeTimeout when no response was received in time, or CodeUnavailable
+	//when the request could not be sent.
+	Call(ctx context.Context, path string, req interface{}, res interface{}) error
+
+	//Close releases the connections of the client
+	Close() error
+}
+
+//DefaultTimeout of calls when the context has no deadline
+const DefaultTimeout = 30 * time.Second
+
+//CallContext applies DefaultTimeout when ctx has no deadline
+func CallContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, DefaultTimeout)
+}
+
+//CallError returns the error when a call failed because ctx is done,
+//else the error when the request could not be sent or received
+func CallError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return micro.Errorf(micro.CodeTimeout, "no response: %v", ctx.Err())
+	}
+	return micro.Errorf(micro.CodeUnavailable, "%v", err)
+}
+
+//Reply is a micro.Result as received by a client
+type Reply struct {
+	Response json.RawMessage `json:"response,omitempty"`
+	Err      *micro.Error    `json:"error,omitempty"`
+}
+
+//DecodeReply decodes a JSON micro.Result into res, or returns its error
+func DecodeReply(data []byte, res interface{}) error {
+	reply := Reply{}
+	if err := json.Unmarshal(data, &reply); err != nil {
+		return micro.Errorf(micro.CodeInternal, "invalid reply: %v", err)
+	}
+	if reply.Err != nil {
+		return reply.Err
+	}
+	return DecodeResponse(reply.Response, res)
+}
+
+//DecodeResponse decodes response data into res, if any
+func DecodeResponse(data []byte, res interface{}) error {
+	if res == nil || len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, res); err != nil {
+		return micro.Errorf(micro.CodeInternal, "cannot decode response into %T: %v", res, err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package mq
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jansemmelink/msf/lib/config"
+	"github.com/jansemmelink/msf/lib/log"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Register("mq", &Config{}, "Configure how message queue listeners are run.")
+}
+
+const defaultDrain = 10
+
+//Config applies to all listeners
+type Config struct {
+	Drain     int      `json:"drain" doc:"Seconds to wait for in-flight requests to complete when stopping. Defaults to 10."`
+	Listeners []string `json:"listeners" doc:"Names of listeners to start, e.g. [\"rest\",\"redis\"]. Defaults to all configured listeners."`
+	OnFailure string   `json:"onFailure" doc:"When a listener fails: stop=stop all listeners, continue=keep others running. Defaults to stop."`
+}
+
+//Failure policies
+const (
+	failStop     = "stop"
+	failContinue = "continue"
+)
+
+//Validate ...
+func (c *Config) Validate() error {
+	if c.Drain <= 0 {
+		c.Drain = defaultDrain
+	}
+	switch c.OnFailure {
+	case "":
+		c.OnFailure = failStop
+	case failStop, failContinue:
+	default:
+		return fmt.Errorf("onFailure=%s is not %s|%s", c.OnFailure, failStop, failContinue)
+	}
+	return nil
+}
+
+//loadConfig gets the configured values, or defaults when not configured
+func loadConfig() (Config, error) {
+	configured, err := config.Get("mq")
+	if err != nil {
+		if _, notFound := errors.Cause(err).(viper.ConfigFileNotFoundError); !notFound {
+			return Config{}, err
+		}
+		log.Debugf("mq not configured, using defaults")
+		c := Config{}
+		c.Validate()
+		return c, nil
+	}
+	return *configured.(*Config), nil
+}
+
+//DrainTimeout is how long to wait for in-flight requests when stopping
+func (c Config) DrainTimeout() time.Duration {
+	return time.Duration(c.Drain) * time.Second
+}
@@ -0,0 +1,33 @@
+package mq
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+//DeadLetter is a queued message that could not be processed,
+//kept with the reason so that it can be inspected and replayed
+type DeadLetter struct {
+	ID        string    `json:"id" doc:"Unique ID of the dead letter."`
+	Listener  string    `json:"listener" doc:"Name of the listener that got the message."`
+	Queue     string    `json:"queue" doc:"Queue where the message was received and where it is replayed."`
+	Reason    string    `json:"reason" doc:"Why the message could not be processed."`
+	Timestamp time.Time `json:"timestamp" doc:"When the message was dead-lettered."`
+	Payload   string    `json:"payload" doc:"The message as it was received."`
+}
+
+var lastDeadLetterID int64
+
+//NewDeadLetter makes a dead letter for a message received by the listener
+func NewDeadLetter(listener, queue, reason, payload string) DeadLetter {
+	now := time.Now()
+	return DeadLetter{
+		ID:        fmt.Sprintf("%d-%d", now.UnixNano(), atomic.AddInt64(&lastDeadLetterID, 1)),
+		Listener:  listener,
+		Queue:     queue,
+		Reason:    reason,
+		Timestamp: now,
+		Payload:   payload,
+	}
+}
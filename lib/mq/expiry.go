@@ -0,0 +1,59 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jansemmelink/msf/lib/audit"
+	"github.com/pkg/errors"
+)
+
+//ErrExpired is returned for requests that expired before they were processed
+var ErrExpired = errors.New("expired")
+
+//Expiry is the policy for requests that wait in a queue
+//A request is sent at a timestamp with a TTL, and it expires at timestamp+TTL.
+//Requests with a negative TTL are invalid, and requests without a TTL (0)
+//are given TTL0 from when they are received, or invalid when TTL0 is 0.
+type Expiry struct {
+	TTL0 time.Duration
+}
+
+//Deadline of a request sent at ts with the specified TTL, received now
+func (e Expiry) Deadline(ts time.Time, ttl time.Duration, now time.Time) (time.Time, error) {
+	switch {
+	case ttl < 0:
+		return time.Time{}, fmt.Errorf("negative ttl %v", ttl)
+	case ttl == 0:
+		if e.TTL0 <= 0 {
+			return time.Time{}, fmt.Errorf("zero ttl (ttl0 not configured)")
+		}
+		return now.Add(e.TTL0), nil
+	}
+	return ts.Add(ttl), nil
+}
+
+//Context returns a context with the request deadline for the handler,
+//or ErrExpired when the deadline has already passed, which is also
+//recorded in the audit trail so that dropped requests can be traced.
+func (e Expiry) Context(listener, oper, id string, ts time.Time, ttl time.Duration) (context.Context, context.CancelFunc, error) {
+	now := time.Now()
+	deadline, err := e.Deadline(ts, ttl, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !now.Before(deadline) {
+		audit.Write(audit.Event{
+			Time:     now,
+			Listener: listener,
+			Oper:     oper,
+			ID:       id,
+			Outcome:  audit.OutcomeExpired,
+			Message:  fmt.Sprintf("expired %v before it was processed", now.Sub(deadline)),
+		})
+		return nil, nil, errors.Wrapf(ErrExpired, "%v ago", now.Sub(deadline))
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	return ctx, cancel, nil
+}
@@ -0,0 +1,47 @@
+package mq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jansemmelink/msf/lib/audit"
+	"github.com/pkg/errors"
+)
+
+type auditEvents []audit.Event
+
+func (a *auditEvents) Write(e audit.Event) { *a = append(*a, e) }
+
+func TestExpiry(t *testing.T) {
+	now := time.Now()
+	e := Expiry{TTL0: time.Minute}
+	if d, err := e.Deadline(now.Add(-time.Hour), 0, now); err != nil || !d.Equal(now.Add(time.Minute)) {
+		t.Errorf("zero ttl: %v %v", d, err)
+	}
+	if d, err := e.Deadline(now, time.Second, now); err != nil || !d.Equal(now.Add(time.Second)) {
+		t.Errorf("ttl: %v %v", d, err)
+	}
+	if _, err := e.Deadline(now, -time.Second, now); err == nil {
+		t.Errorf("negative ttl accepted")
+	}
+	if _, err := (Expiry{}).Deadline(now, 0, now); err == nil {
+		t.Errorf("zero ttl accepted without ttl0")
+	}
+
+	events := &auditEvents{}
+	audit.SetWriter(events)
+	ctx, cancel, err := e.Context("test", "/a/b", "1", now, time.Hour)
+	if err != nil {
+		t.Fatalf("valid request failed: %v", err)
+	}
+	if deadline, ok := ctx.Deadline(); !ok || !deadline.Equal(now.Add(time.Hour)) {
+		t.Errorf("wrong deadline: %v", deadline)
+	}
+	cancel()
+	if _, _, err := e.Context("test", "/a/b", "2", now.Add(-time.Minute), time.Second); errors.Cause(err) != ErrExpired {
+		t.Errorf("expired request: %v", err)
+	}
+	if len(*events) != 1 || (*events)[0].ID != "2" || (*events)[0].Outcome != audit.OutcomeExpired {
+		t.Errorf("wrong audit events: %+v", *events)
+	}
+}
@@ -1,12 +1,17 @@
 package mq
 
 import (
+	"context"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/jansemmelink/msf/lib/config"
 	"github.com/jansemmelink/msf/lib/log"
 	"github.com/jansemmelink/msf/lib/micro"
+	"github.com/pkg/errors"
 )
 
 //Add a listener implementation that can be configured
@@ -28,50 +33,135 @@ func Add(name string, implementation IListener, doc string) {
 var (
 	implementationsMutex = sync.Mutex{}
 	implementations      = make(map[string]IListener)
-	defaultListener      IListener
 )
 
 //IListener ...
 type IListener interface {
 	config.IConfigurable
-	Listen(d micro.IDomain)
+
+	//Listen processes requests for domain d until ctx is done,
+	//then stops taking new requests and waits up to DrainTimeout()
+	//for requests in progress before it returns.
+	Listen(ctx context.Context, d micro.IDomain) error
 }
 
-//Listener ...
-type Listener struct{}
-
-//Listen using configured listener
-func Listen(d micro.IDomain) {
-	names := ""
-	if defaultListener == nil {
-		log.Debugf("Looking for one of %d listeners in config", len(implementations))
-		for name /*,listenerImplementation*/ := range implementations {
-			log.Debugf("  Trying %s ...", name)
-			names += "|" + name
-
-			configuredListener, err := config.Get("mq." + name) //, listenerImplementation)
-			if err != nil {
-				log.Debugf("    mq.%s not available: %v", name, err)
-				continue
-			}
+//Listener must be embedded in listener implementations
+type Listener struct {
+	drain time.Duration
+}
 
-			err = configuredListener.Validate()
-			if err != nil {
-				log.Debugf("    mq.%s is not valid: %v", name, err)
-				continue
-			}
+//DrainTimeout is how long to wait for requests in progress when stopping
+func (l Listener) DrainTimeout() time.Duration {
+	if l.drain <= 0 {
+		return defaultDrain * time.Second
+	}
+	return l.drain
+}
+
+func (l *Listener) setDrainTimeout(drain time.Duration) {
+	l.drain = drain
+}
+
+//Listen using all configured listeners until ctx is done
+//Listeners are started in the configured order, or by name when no
+//order is configured. When one of them fails, the others are stopped
+//or kept running according to the configured failure policy.
+func Listen(ctx context.Context, d micro.IDomain) error {
+	c, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	listeners, err := configured(c)
+	if err != nil {
+		return err
+	}
+	return listen(ctx, d, c, listeners)
+}
+
+//listen runs the listeners until ctx is done or they failed
+func listen(ctx context.Context, d micro.IDomain, c Config, listeners []namedListener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type outcome struct {
+		name string
+		err  error
+	}
+	outcomes := make(chan outcome, len(listeners))
+	started := make([]string, 0, len(listeners))
+	for _, l := range listeners {
+		if s, ok := l.listener.(interface{ setDrainTimeout(time.Duration) }); ok {
+			s.setDrainTimeout(c.DrainTimeout())
+		}
+		go func(name string, listener IListener) {
+			outcomes <- outcome{name: name, err: listener.Listen(ctx, d)}
+		}(l.name, l.listener)
+		started = append(started, "mq."+l.name)
+	}
+	log.Infof("Started %d listeners: %s", len(started), strings.Join(started, ","))
 
-			log.Infof("Using mq.%s", name)
-			defaultListener = configuredListener.(IListener)
-			break
+	var firstErr error
+	for range listeners {
+		o := <-outcomes
+		if o.err == nil {
+			log.Infof("mq.%s stopped", o.name)
+			continue
+		}
+		log.Errorf("mq.%s failed: %v", o.name, o.err)
+		if firstErr == nil {
+			firstErr = errors.Wrapf(o.err, "mq.%s failed", o.name)
+		}
+		if c.OnFailure == failStop && ctx.Err() == nil {
+			log.Errorf("Stopping all listeners because mq.%s failed", o.name)
+			cancel()
 		}
 	}
+	log.Infof("Stopped listening")
+	return firstErr
+}
+
+type namedListener struct {
+	name     string
+	listener IListener
+}
 
-	if defaultListener == nil {
-		if len(names) > 0 {
-			names = names[1:]
+//configured returns the listeners to start
+func configured(c Config) ([]namedListener, error) {
+	implementationsMutex.Lock()
+	defer implementationsMutex.Unlock()
+
+	names := c.Listeners
+	required := len(names) > 0
+	if !required {
+		for name := range implementations {
+			names = append(names, name)
 		}
-		log.Fatalf("No mq listener configured, expecting mq.%s", names)
+		sort.Strings(names)
+	}
+
+	log.Debugf("Looking for %d listeners in config", len(names))
+	listeners := make([]namedListener, 0)
+	for _, name := range names {
+		if _, ok := implementations[name]; !ok {
+			return nil, fmt.Errorf("unknown listener mq.%s", name)
+		}
+		log.Debugf("  Trying %s ...", name)
+		configuredListener, err := config.Get("mq." + name)
+		if err == nil {
+			err = configuredListener.Validate()
+		}
+		if err != nil {
+			if required {
+				return nil, errors.Wrapf(err, "mq.%s not available", name)
+			}
+			log.Debugf("    mq.%s not available: %v", name, err)
+			continue
+		}
+		log.Infof("Using mq.%s", name)
+		listeners = append(listeners, namedListener{name: name, listener: configuredListener.(IListener)})
+	}
+
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("no mq listener configured, expecting mq.%s", strings.Join(names, "|"))
 	}
-	defaultListener.Listen(d)
+	return listeners, nil
 }
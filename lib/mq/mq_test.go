@@ -0,0 +1,130 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jansemmelink/msf/lib/micro"
+)
+
+type fakeListener struct {
+	Listener
+	fail    error
+	failed  chan struct{}
+	stopped chan struct{}
+}
+
+func newFake(fail error) *fakeListener {
+	return &fakeListener{fail: fail, failed: make(chan struct{}), stopped: make(chan struct{})}
+}
+
+func (f *fakeListener) Validate() error { return nil }
+
+func (f *fakeListener) Listen(ctx context.Context, d micro.IDomain) error {
+	if f.fail != nil {
+		close(f.failed)
+		return f.fail
+	}
+	<-ctx.Done()
+	close(f.stopped)
+	return nil
+}
+
+func init() {
+	Add("fakeA", newFake(nil), "fake A")
+	Add("fakeB", newFake(nil), "fake B")
+	Add("fakeC", newFake(nil), "fake C")
+}
+
+func TestConfigured(t *testing.T) {
+	//fakeA and fakeB are configured, fakeC is not
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "conf"), 0755)
+	for _, name := range []string{"mq.fakeA", "mq.fakeB"} {
+		if err := os.WriteFile(filepath.Join(dir, "conf", name+".json"), []byte("{}"), 0644); err != nil {
+			t.Fatalf("cannot write config: %v", err)
+		}
+	}
+	t.Chdir(dir)
+
+	for _, test := range []struct {
+		listeners []string
+		expected  string
+	}{
+		{nil, "fakeA,fakeB"},
+		{[]string{"fakeB", "fakeA"}, "fakeB,fakeA"},
+		{[]string{"fakeA", "unknown"}, "unknown listener mq.unknown"},
+		{[]string{"fakeC"}, "mq.fakeC not available"},
+	} {
+		listeners, err := configured(Config{Listeners: test.listeners})
+		names := []string{}
+		for _, l := range listeners {
+			names = append(names, l.name)
+		}
+		result := strings.Join(names, ",")
+		if err != nil {
+			result = err.Error()
+		}
+		if !strings.HasPrefix(result, test.expected) {
+			t.Errorf("%v: %s, expected %s", test.listeners, result, test.expected)
+		}
+	}
+
+	if err := (&Config{OnFailure: "retry"}).Validate(); err == nil {
+		t.Errorf("unknown onFailure accepted")
+	}
+}
+
+func TestListenStop(t *testing.T) {
+	failing, other := newFake(fmt.Errorf("broken")), newFake(nil)
+	done := make(chan error)
+	go func() {
+		done <- listen(context.Background(), micro.Root(), Config{OnFailure: failStop}, []namedListener{{"failing", failing}, {"other", other}})
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "mq.failing failed: broken") {
+			t.Fatalf("wrong error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("other listener not stopped")
+	}
+	select {
+	case <-other.stopped:
+	default:
+		t.Fatalf("other listener did not stop")
+	}
+}
+
+func TestListenContinue(t *testing.T) {
+	failing, other := newFake(fmt.Errorf("broken")), newFake(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- listen(ctx, micro.Root(), Config{OnFailure: failContinue}, []namedListener{{"failing", failing}, {"other", other}})
+	}()
+	<-failing.failed
+	select {
+	case <-other.stopped:
+		t.Fatalf("other listener stopped")
+	case err := <-done:
+		t.Fatalf("listen returned: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "mq.failing failed: broken") {
+			t.Fatalf("wrong error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("listen did not stop")
+	}
+	<-other.stopped
+}
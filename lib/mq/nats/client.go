@@ -0,0 +1,50 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/jansemmelink/msf/lib/micro"
+	"github.com/jansemmelink/msf/lib/mq"
+	"github.com/nats-io/nats.go"
+)
+
+//NewClient calls operations with NATS requests to the server at url,
+//on subjects made of the prefix and the operation path, e.g. with prefix
+//"api" operation "/billing/invoice/get" is called on "api.billing.invoice.get"
+func NewClient(url string, prefix string) (mq.IClient, error) {
+	nc, err := nats.Connect(url, nats.Name("msf-client"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, micro.Errorf(micro.CodeUnavailable, "cannot connect to NATS %s: %v", url, err)
+	}
+	return client{nc: nc, prefix: prefix}, nil
+}
+
+type client struct {
+	nc     *nats.Conn
+	prefix string
+}
+
+func (c client) Call(ctx context.Context, path string, req interface{}, res interface{}) error {
+	ctx, cancel := mq.CallContext(ctx)
+	defer cancel()
+	data, err := json.Marshal(req)
+	if err != nil {
+		return micro.Errorf(micro.CodeInvalid, "cannot encode request %T: %v", req, err)
+	}
+	tokens := strings.Split(strings.Trim(path, "/"), "/")
+	if c.prefix != "" {
+		tokens = append([]string{c.prefix}, tokens...)
+	}
+	msg, err := c.nc.RequestWithContext(ctx, strings.Join(tokens, "."), data)
+	if err != nil {
+		return mq.CallError(ctx, err)
+	}
+	return mq.DecodeReply(msg.Data, res)
+}
+
+func (c client) Close() error {
+	c.nc.Close()
+	return nil
+}
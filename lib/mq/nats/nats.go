@@ -1,34 +1,153 @@
 package nats
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/jansemmelink/msf/lib/log"
 	"github.com/jansemmelink/msf/lib/micro"
 	"github.com/jansemmelink/msf/lib/mq"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
 )
 
 func init() {
 	mq.Add("nats", &popper{}, "NATS")
 }
 
+//Requests are received on subjects where the tokens after the fixed prefix
+//of the configured subject name the domain and operation, e.g. with subject
+//"api.>" a request on "api.billing.invoice.get" invokes /billing/invoice/get.
+//The request data is the JSON operation request, and when the message has a
+//reply subject, the micro.Result is sent there as JSON without the audit.
 type popper struct {
 	mq.Listener
-	Subject string
+	URL           string `json:"url" doc:"NATS Server URL. Defaults to nats://localhost:4222."`
+	Subject       string `json:"subject" doc:"Subject to subscribe to, e.g. api.>"`
+	Queue         string `json:"queue" doc:"Queue group to share requests with other instances. Defaults to the subject."`
+	MaxConcurrent int    `json:"maxConcurrent" doc:"Max concurrent requests. Defaults to 100."`
+	Timeout       int    `json:"timeout" doc:"Milliseconds allowed to handle a request. Defaults to 60000."`
 }
 
-func (p popper) Validate() error {
+func (p *popper) Validate() error {
+	if p.URL == "" {
+		p.URL = nats.DefaultURL
+	}
 	if p.Subject == "" {
 		return fmt.Errorf("missing subject=... ")
 	}
+	if p.Queue == "" {
+		p.Queue = p.Subject
+	}
+	if p.MaxConcurrent < 1 {
+		p.MaxConcurrent = 100
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = 60000
+	}
 	log.Debugf("popper validated: %+v", p)
-	return fmt.Errorf("NYI") //nil
+	return nil
 }
 
-//Listen ...
-func (p popper) Listen(d micro.IDomain) {
+//Listen subscribes to the subject until ctx is done
+//Each subscription in the queue group handles one request at a time,
+//so MaxConcurrent subscriptions are made to handle requests concurrently
+//and the server balances requests over them and over other instances.
+func (p popper) Listen(ctx context.Context, d micro.IDomain) error {
 	log.Debugf("NATS Listening to %s ...", p.Subject)
-	for {
-		log.Fatalf("NYI")
+	closed := make(chan struct{})
+	nc, err := nats.Connect(p.URL,
+		nats.Name("msf"),
+		nats.MaxReconnects(-1),
+		nats.DrainTimeout(p.DrainTimeout()),
+		nats.ClosedHandler(func(*nats.Conn) { close(closed) }),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			log.Errorf("NATS(%s) disconnected: %v", p.Subject, err)
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			log.Infof("NATS(%s) reconnected to %s", p.Subject, nc.ConnectedUrl())
+		}),
+	)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to connect to NATS %s", p.URL)
+	}
+
+	prefix := p.prefix()
+	for i := 0; i < p.MaxConcurrent; i++ {
+		if _, err := nc.QueueSubscribe(p.Subject, p.Queue, func(msg *nats.Msg) {
+			p.handle(d, prefix, msg)
+		}); err != nil {
+			nc.Close()
+			return errors.Wrapf(err, "Failed to subscribe to %s", p.Subject)
+		}
+	}
+	if err := nc.Flush(); err != nil {
+		nc.Close()
+		return errors.Wrapf(err, "Failed to subscribe to %s", p.Subject)
+	}
+
+	select {
+	case <-closed:
+		return fmt.Errorf("NATS(%s) connection closed: %v", p.Subject, nc.LastError())
+	case <-ctx.Done():
+	}
+
+	//stop taking requests and wait for requests in progress
+	log.Infof("NATS stopping, waiting up to %v for requests in progress...", p.DrainTimeout())
+	if err := nc.Drain(); err != nil {
+		nc.Close()
+		return errors.Wrapf(err, "Failed to stop NATS")
+	}
+	<-closed
+	if err := nc.LastError(); err == nats.ErrDrainTimeout {
+		return fmt.Errorf("NATS(%s) stopped with requests still in progress", p.Subject)
+	}
+	return nil
+}
+
+//prefix is the nr of subject tokens before the first wildcard
+func (p popper) prefix() int {
+	tokens := strings.Split(p.Subject, ".")
+	for i, token := range tokens {
+		if token == "*" || token == ">" {
+			return i
+		}
+	}
+	return 0
+}
+
+//handle a request and reply, if the message has a reply subject
+func (p popper) handle(d micro.IDomain, prefix int, msg *nats.Msg) {
+	tokens := strings.Split(msg.Subject, ".")[prefix:]
+	domainPath := "/" + strings.Join(tokens[:len(tokens)-1], "/")
+	operName := tokens[len(tokens)-1]
+	log.Debugf("NATS %s: domain=%s oper=%s", msg.Subject, domainPath, operName)
+
+	reqCtx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Millisecond)
+	defer cancel()
+	result := micro.Invoke(d, micro.Request{
+		Path:    domainPath,
+		Oper:    operName,
+		Data:    msg.Data,
+		Context: reqCtx,
+	})
+	if result.Err != nil {
+		log.Errorf("NATS %s failed: %v", msg.Subject, result.Err)
+	}
+
+	if msg.Reply == "" {
+		log.Debugf("NATS %s -> %+v (no reply)", msg.Subject, result.Response)
+		return
+	}
+	reply, err := json.Marshal(micro.Result{Response: result.Response, Err: result.Err})
+	if err != nil {
+		log.Errorf("NATS %s: Failed to encode response: %v", msg.Subject, err)
+		reply, _ = json.Marshal(micro.Result{Err: micro.Errorf(micro.CodeInternal, "failed to encode response")})
+	}
+	if err := msg.Respond(reply); err != nil {
+		log.Errorf("NATS %s: Failed to reply to %s: %v", msg.Subject, msg.Reply, err)
 	}
 }
@@ -0,0 +1,132 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jansemmelink/msf/lib/micro"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+type echo struct {
+	micro.Service
+	Text string `json:"text" validate:"required"`
+}
+
+func (e *echo) Validate() error { return nil }
+
+func (e echo) Handle() (interface{}, interface{}) { return e.Text, nil }
+
+func init() {
+	micro.Domain("test").AddName("echo", &echo{})
+}
+
+//startPopper runs a popper against an in-process NATS server
+func startPopper(t *testing.T, p *popper) (*nats.Conn, func()) {
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatalf("cannot create NATS server: %v", err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatalf("NATS server not ready")
+	}
+	t.Cleanup(s.Shutdown)
+
+	p.URL = s.ClientURL()
+	subs := s.NumSubscriptions()
+	if err := p.Validate(); err != nil {
+		t.Fatalf("invalid popper: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() {
+		stopped <- p.Listen(ctx, micro.Root())
+	}()
+
+	client, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("cannot connect: %v", err)
+	}
+	t.Cleanup(client.Close)
+	//wait for the subscriptions
+	for i := 0; i < 100 && s.NumSubscriptions() < subs+uint32(p.MaxConcurrent); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return client, func() {
+		cancel()
+		if err := <-stopped; err != nil {
+			t.Errorf("Listen failed: %v", err)
+		}
+	}
+}
+
+func TestRequestReply(t *testing.T) {
+	client, stop := startPopper(t, &popper{Subject: "api.>", MaxConcurrent: 2})
+	defer stop()
+
+	for subject, expected := range map[string]micro.Result{
+		"api.test.echo":    {Response: "hello"},
+		"api.test.unknown": {Err: &micro.Error{Code: micro.CodeNotFound}},
+		"api.other.echo":   {Err: &micro.Error{Code: micro.CodeNotFound}},
+	} {
+		msg, err := client.Request(subject, []byte(`{"text":"hello"}`), 5*time.Second)
+		if err != nil {
+			t.Fatalf("%s: no reply: %v", subject, err)
+		}
+		result := micro.Result{}
+		if err := json.Unmarshal(msg.Data, &result); err != nil {
+			t.Fatalf("%s: invalid reply: %v: %s", subject, err, msg.Data)
+		}
+		if result.Response != expected.Response || (result.Err == nil) != (expected.Err == nil) || (result.Err != nil && result.Err.Code != expected.Err.Code) {
+			t.Errorf("%s: %s", subject, msg.Data)
+		}
+	}
+
+	msg, err := client.Request("api.test.echo", []byte(`{}`), 5*time.Second)
+	if err != nil {
+		t.Fatalf("no reply: %v", err)
+	}
+	result := micro.Result{}
+	json.Unmarshal(msg.Data, &result)
+	if result.Err == nil || result.Err.Code != micro.CodeInvalid {
+		t.Errorf("not invalid: %s", msg.Data)
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	for subject, expected := range map[string]int{"api.>": 1, "a.b.*.>": 2, "test.echo": 0, ">": 0} {
+		if prefix := (popper{Subject: subject}).prefix(); prefix != expected {
+			t.Errorf("prefix(%s)=%d != %d", subject, prefix, expected)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	p := &popper{Subject: "api.>", MaxConcurrent: 1}
+	_, stop := startPopper(t, p)
+	defer stop()
+	c, err := NewClient(p.URL, "api")
+	if err != nil {
+		t.Fatalf("no client: %v", err)
+	}
+	defer c.Close()
+
+	var text string
+	if err := c.Call(context.Background(), "/test/echo", echo{Text: "hello"}, &text); err != nil || text != "hello" {
+		t.Fatalf("call failed: %v %s", err, text)
+	}
+	err = c.Call(context.Background(), "/test/echo", echo{}, &text)
+	if e, ok := err.(*micro.Error); !ok || e.Code != micro.CodeInvalid {
+		t.Fatalf("wrong error: %v", err)
+	}
+	other, _ := NewClient(p.URL, "other")
+	defer other.Close()
+	err = other.Call(context.Background(), "/test/echo", echo{}, &text)
+	if e, ok := err.(*micro.Error); !ok || e.Code != micro.CodeUnavailable {
+		t.Fatalf("wrong error: %v", err)
+	}
+}
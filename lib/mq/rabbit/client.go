@@ -0,0 +1,141 @@
+package rabbit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jansemmelink/msf/lib/log"
+	"github.com/jansemmelink/msf/lib/micro"
+	"github.com/jansemmelink/msf/lib/mq"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+//replyTo is the RabbitMQ pseudo queue for replies directly to the caller
+const replyTo = "amq.rabbitmq.reply-to"
+
+//NewClient calls operations by publishing requests to the exchange with the
+//routing key, e.g. exchange "" and the queue name as routing key to send them
+//directly to the queue, with the operation path in HeaderOper. Responses are
+//received with direct reply-to and matched with the calls by correlation_id.
+func NewClient(url string, exchange string, key string) (mq.IClient, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, micro.Errorf(micro.CodeUnavailable, "cannot connect to %s: %v", url, err)
+	}
+	ch, err := conn.Channel()
+	if err == nil {
+		var replies <-chan amqp.Delivery
+		if replies, err = ch.Consume(replyTo, "", true, true, false, false, nil); err == nil {
+			return newClient(ch, conn, replies, exchange, key), nil
+		}
+	}
+	conn.Close()
+	return nil, micro.Errorf(micro.CodeUnavailable, "cannot receive replies: %v", err)
+}
+
+//publisher is the part of an amqp.Channel used by the client
+type publisher interface {
+	PublishWithContext(ctx context.Context, exchange string, key string, mandatory bool, immediate bool, msg amqp.Publishing) error
+}
+
+//newClient publishes requests with ch and receives the replies until they are closed
+func newClient(ch publisher, conn io.Closer, replies <-chan amqp.Delivery, exchange string, key string) *client {
+	c := &client{
+		conn:     conn,
+		ch:       ch,
+		exchange: exchange,
+		key:      key,
+		id:       fmt.Sprintf("%d", time.Now().UnixNano()),
+		pending:  make(map[string]chan amqp.Delivery),
+	}
+	go c.receive(replies)
+	return c
+}
+
+type client struct {
+	conn     io.Closer
+	ch       publisher
+	exchange string
+	key      string
+	id       string
+	seq      int64
+	mutex    sync.Mutex
+	pending  map[string]chan amqp.Delivery
+}
+
+func (c *client) Call(ctx context.Context, path string, req interface{}, res interface{}) error {
+	ctx, cancel := mq.CallContext(ctx)
+	defer cancel()
+	body, err := json.Marshal(req)
+	if err != nil {
+		return micro.Errorf(micro.CodeInvalid, "cannot encode request %T: %v", req, err)
+	}
+	correlationID := fmt.Sprintf("%s-%d", c.id, atomic.AddInt64(&c.seq, 1))
+	replies := make(chan amqp.Delivery, 1)
+	c.mutex.Lock()
+	c.pending[correlationID] = replies
+	c.mutex.Unlock()
+	defer func() {
+		c.mutex.Lock()
+		delete(c.pending, correlationID)
+		c.mutex.Unlock()
+	}()
+
+	msg := amqp.Publishing{
+		Headers:       amqp.Table{HeaderOper: path},
+		ContentType:   "application/json",
+		CorrelationId: correlationID,
+		ReplyTo:       replyTo,
+		Timestamp:     time.Now(),
+		Body:          body,
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		msg.Expiration = fmt.Sprintf("%d", time.Until(deadline)/time.Millisecond)
+	}
+	if err := c.ch.PublishWithContext(ctx, c.exchange, c.key, false, false, msg); err != nil {
+		return mq.CallError(ctx, err)
+	}
+
+	select {
+	case reply, ok := <-replies:
+		if !ok {
+			return micro.Errorf(micro.CodeUnavailable, "connection closed")
+		}
+		return mq.DecodeReply(reply.Body, res)
+	case <-ctx.Done():
+		return mq.CallError(ctx, ctx.Err())
+	}
+}
+
+//receive replies and hand them to the calls waiting for them,
+//until the connection is closed
+func (c *client) receive(replies <-chan amqp.Delivery) {
+	for reply := range replies {
+		c.mutex.Lock()
+		pending, ok := c.pending[reply.CorrelationId]
+		c.mutex.Unlock()
+		if !ok {
+			log.Debugf("Rabbit: Discard late reply to %s", reply.CorrelationId)
+			continue
+		}
+		select {
+		case pending <- reply:
+		default: //duplicate reply
+		}
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for id, pending := range c.pending {
+		close(pending)
+		delete(c.pending, id)
+	}
+}
+
+func (c *client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,177 @@
+package rabbit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/jansemmelink/msf/lib/micro"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type echo struct {
+	micro.Service
+	Text string `json:"text" validate:"required"`
+}
+
+func (e *echo) Validate() error { return nil }
+
+func (e echo) Handle() (interface{}, interface{}) { return micro.MustHandle(e) }
+
+func (e echo) HandleErr() (interface{}, interface{}, error) {
+	if e.Text == "busy" {
+		return nil, nil, micro.Errorf(micro.CodeUnavailable, "try again")
+	}
+	if e.Text == "boom" {
+		panic("crash")
+	}
+	return e.Text, nil, nil
+}
+
+func init() {
+	micro.Domain("test").AddName("echo", &echo{Text: "x"})
+}
+
+//acknowledger records what was done with a delivery
+type acknowledger struct {
+	outcome string
+}
+
+func (a *acknowledger) Ack(tag uint64, multiple bool) error {
+	a.outcome = "ack"
+	return nil
+}
+
+func (a *acknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	return a.Reject(tag, requeue)
+}
+
+func (a *acknowledger) Reject(tag uint64, requeue bool) error {
+	a.outcome = "reject"
+	if requeue {
+		a.outcome = "requeue"
+	}
+	return nil
+}
+
+func TestRoute(t *testing.T) {
+	for _, c := range []struct {
+		bindingKey, routingKey, header, path, oper string
+	}{
+		{"api.#", "api.test.echo", "", "/test", "echo"},
+		{"#", "test.echo", "", "/test", "echo"},
+		{"api.*.echo", "api.test.echo", "", "/test", "echo"},
+		{"#", "echo", "", "/", "echo"},
+		{"#", "anything", "/a/b/c", "/a/b", "c"},
+	} {
+		delivery := amqp.Delivery{RoutingKey: c.routingKey, Headers: amqp.Table{}}
+		if c.header != "" {
+			delivery.Headers[HeaderOper] = c.header
+		}
+		if path, oper := (consumer{BindingKey: c.bindingKey}).route(delivery); path != c.path || oper != c.oper {
+			t.Errorf("%+v: routed to %s %s", c, path, oper)
+		}
+	}
+}
+
+func TestHandle(t *testing.T) {
+	p := consumer{Queue: "test", BindingKey: "api.#"}
+	p.Validate()
+	for _, c := range []struct {
+		text        string
+		redelivered bool
+		outcome     string
+		code        micro.Code
+	}{
+		{"hello", false, "ack", ""},
+		{"", false, "ack", micro.CodeInvalid},
+		{"boom", false, "reject", micro.CodeInternal},
+		{"busy", false, "requeue", micro.CodeUnavailable},
+		{"busy", true, "reject", micro.CodeUnavailable},
+	} {
+		a := &acknowledger{}
+		body, _ := json.Marshal(map[string]string{"text": c.text})
+		var replyTo string
+		var reply amqp.Publishing
+		p.handle(micro.Root(), amqp.Delivery{
+			Acknowledger:  a,
+			RoutingKey:    "api.test.echo",
+			ReplyTo:       "replies",
+			CorrelationId: "123",
+			Redelivered:   c.redelivered,
+			Body:          body,
+		}, func(key string, msg amqp.Publishing) error {
+			replyTo, reply = key, msg
+			return nil
+		})
+		if a.outcome != c.outcome {
+			t.Errorf("%+v: %s", c, a.outcome)
+		}
+		result := micro.Result{}
+		if err := json.Unmarshal(reply.Body, &result); err != nil || replyTo != "replies" || reply.CorrelationId != "123" {
+			t.Fatalf("%+v: wrong reply to %s: %v %+v", c, replyTo, err, reply)
+		}
+		if (result.Err == nil && c.code != "") || (result.Err != nil && result.Err.Code != c.code) || (c.code == "" && result.Response != c.text) {
+			t.Errorf("%+v: wrong result: %s", c, reply.Body)
+		}
+	}
+}
+
+//broker passes published requests to a consumer and its replies back to the client
+type broker struct {
+	consumer consumer
+	replies  chan amqp.Delivery
+}
+
+func (b *broker) PublishWithContext(ctx context.Context, exchange string, key string, mandatory bool, immediate bool, msg amqp.Publishing) error {
+	go b.consumer.handle(micro.Root(), amqp.Delivery{
+		Acknowledger:  &acknowledger{},
+		Headers:       msg.Headers,
+		RoutingKey:    key,
+		ReplyTo:       msg.ReplyTo,
+		CorrelationId: msg.CorrelationId,
+		Expiration:    msg.Expiration,
+		Body:          msg.Body,
+	}, func(key string, reply amqp.Publishing) error {
+		if key != replyTo {
+			return fmt.Errorf("reply to %s", key)
+		}
+		b.replies <- amqp.Delivery{CorrelationId: reply.CorrelationId, Body: reply.Body}
+		return nil
+	})
+	return nil
+}
+
+func (b *broker) Close() error {
+	close(b.replies)
+	return nil
+}
+
+func TestClient(t *testing.T) {
+	b := &broker{consumer: consumer{Queue: "test", BindingKey: "#"}, replies: make(chan amqp.Delivery)}
+	b.consumer.Validate()
+	c := newClient(b, b, b.replies, "", "test")
+	defer c.Close()
+
+	var text string
+	if err := c.Call(context.Background(), "/test/echo", echo{Text: "hello"}, &text); err != nil || text != "hello" {
+		t.Fatalf("call failed: %v %s", err, text)
+	}
+	for _, test := range []struct {
+		text string
+		code micro.Code
+	}{
+		{"", micro.CodeInvalid},
+		{"busy", micro.CodeUnavailable},
+	} {
+		err := c.Call(context.Background(), "/test/echo", echo{Text: test.text}, &text)
+		if e, ok := err.(*micro.Error); !ok || e.Code != test.code {
+			t.Errorf("%s: wrong error: %v", test.text, err)
+		}
+	}
+	err := c.Call(context.Background(), "/test/unknown", echo{Text: "hello"}, &text)
+	if e, ok := err.(*micro.Error); !ok || e.Code != micro.CodeNotFound {
+		t.Errorf("wrong error: %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jansemmelink/msf/lib/log"
+	"github.com/jansemmelink/msf/lib/micro"
+	"github.com/jansemmelink/msf/lib/mq"
+)
+
+//NewClient calls operations by pushing requests to queue qname on the REDIS
+//server at addr ("host:port"). Responses are pushed by the service to a reply
+//queue of the client, and matched with the calls by header.int_guid.
+func NewClient(addr string, qname string) (mq.IClient, error) {
+	return newClient(addr, qname, modeList)
+}
+
+//NewStreamClient calls operations like NewClient, but adds the requests
+//to stream qname for a listener in stream mode
+func NewStreamClient(addr string, qname string) (mq.IClient, error) {
+	return newClient(addr, qname, modeStream)
+}
+
+func newClient(addr string, qname string, mode string) (mq.IClient, error) {
+	pool, err := NewRedis("tcp", addr, 10)
+	if err != nil {
+		return nil, micro.Errorf(micro.CodeUnavailable, "cannot connect to REDIS: %v", err)
+	}
+	hostname, _ := os.Hostname()
+	id := fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), time.Now().UnixNano())
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &client{
+		pool:    pool,
+		qname:   qname,
+		mode:    mode,
+		replyQ:  qname + ":reply:" + id,
+		id:      id,
+		pending: make(map[string]chan response),
+		stop:    cancel,
+		done:    make(chan struct{}),
+	}
+	go c.receive(ctx)
+	return c, nil
+}
+
+type client struct {
+	pool    Redis
+	qname   string
+	mode    string
+	replyQ  string
+	id      string
+	seq     int64
+	mutex   sync.Mutex
+	pending map[string]chan response
+	stop    context.CancelFunc
+	done    chan struct{}
+}
+
+//response is a Message as received by a client
+type response struct {
+	Header   *Header         `json:"header"`
+	Response json.RawMessage `json:"response,omitempty"`
+}
+
+func (c *client) Call(ctx context.Context, path string, req interface{}, res interface{}) error {
+	ctx, cancel := mq.CallContext(ctx)
+	defer cancel()
+	request, err := json.Marshal(req)
+	if err != nil {
+		return micro.Errorf(micro.CodeInvalid, "cannot encode request %T: %v", req, err)
+	}
+	deadline, _ := ctx.Deadline()
+	now := time.Now()
+	guid := fmt.Sprintf("%s-%d", c.id, atomic.AddInt64(&c.seq, 1))
+	msg, _ := json.Marshal(Message{
+		Header: &Header{
+			IntGUID:   guid,
+			Timestamp: now.Format(TimestampFormat),
+			TTL:       int(deadline.Sub(now) / time.Millisecond),
+			Provider:  &Provider{Name: path},
+			Consumer:  &Consumer{Name: c.replyQ},
+		},
+		Request: request,
+	})
+
+	replies := make(chan response, 1)
+	c.mutex.Lock()
+	c.pending[guid] = replies
+	c.mutex.Unlock()
+	defer func() {
+		c.mutex.Lock()
+		delete(c.pending, guid)
+		c.mutex.Unlock()
+	}()
+	if c.mode == modeStream {
+		_, err = c.pool.XADD(c.qname, map[string]interface{}{streamField: string(msg)})
+	} else {
+		err = c.pool.LPUSH(c.qname, string(msg))
+	}
+	if err != nil {
+		return mq.CallError(ctx, err)
+	}
+
+	select {
+	case reply := <-replies:
+		if r := reply.Header.Result; r == nil || r.Code != CodeOK {
+			if r == nil {
+				return micro.Errorf(micro.CodeInternal, "reply without header.result")
+			}
+			return &micro.Error{Code: r.Code, Message: r.Message, Details: r.Details}
+		}
+		return mq.DecodeResponse(reply.Response, res)
+	case <-ctx.Done():
+		return mq.CallError(ctx, ctx.Err())
+	}
+}
+
+//receive replies and hand them to the calls waiting for them
+func (c *client) receive(ctx context.Context) {
+	defer close(c.done)
+	backoff := mq.Backoff{Min: 100 * time.Millisecond, Max: 5 * time.Second}
+	for ctx.Err() == nil {
+		data, err := c.pool.BRPOP(c.replyQ, 1)
+		if err != nil {
+			log.Errorf("%s: Failed to get replies: %v", c.replyQ, err)
+			backoff.Wait(ctx)
+			continue
+		}
+		backoff.Reset()
+		if data == "" {
+			continue
+		}
+		reply := response{}
+		if err := json.Unmarshal([]byte(data), &reply); err != nil || reply.Header == nil {
+			log.Errorf("%s: Discard invalid reply: %v: %s", c.replyQ, err, data)
+			continue
+		}
+		c.mutex.Lock()
+		replies, ok := c.pending[reply.Header.IntGUID]
+		c.mutex.Unlock()
+		if !ok {
+			log.Debugf("%s: Discard late reply to %s", c.replyQ, reply.Header.IntGUID)
+			continue
+		}
+		select {
+		case replies <- reply:
+		default: //duplicate reply
+		}
+	}
+}
+
+func (c *client) Close() error {
+	c.stop()
+	<-c.done
+	c.pool.DEL(c.replyQ)
+	return c.pool.Close()
+}
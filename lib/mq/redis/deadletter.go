@@ -0,0 +1,254 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/jansemmelink/msf/lib/config"
+	"github.com/jansemmelink/msf/lib/log"
+	"github.com/jansemmelink/msf/lib/micro"
+	"github.com/jansemmelink/msf/lib/mq"
+)
+
+func init() {
+	//management operations on the configured dead letter queue
+	dlq := micro.Domain("redis").Sub("deadletter")
+	dlq.AddName("list", &dlqList{})
+	dlq.AddName("inspect", &dlqInspect{})
+	dlq.AddName("replay", &dlqReplay{})
+	dlq.AddName("purge", &dlqPurge{})
+}
+
+//deadLetter pushes a message that could not be processed to the dead letter queue
+func (p popper) deadLetter(pool Redis, reason string, data string) {
+	if p.DeadLetter == "" {
+		return
+	}
+	dl := mq.NewDeadLetter("redis", p.QName, reason, data)
+	jsonDL, _ := json.Marshal(dl)
+	if err := pool.LPUSH(p.DeadLetter, string(jsonDL)); err != nil {
+		log.Errorf("%s: Failed to push to dead letter queue %s: %v: %s", p.QName, p.DeadLetter, err, data)
+		return
+	}
+	log.Debugf("%s: Dead letter %s pushed to %s: %s", p.QName, dl.ID, p.DeadLetter, reason)
+}
+
+//deadLetters connects to the configured dead letter queue
+func deadLetters() (Redis, string, error) {
+	c, err := config.Get("mq.redis")
+	if err != nil {
+		return nil, "", micro.Errorf(micro.CodeUnavailable, "mq.redis not configured: %v", err)
+	}
+	p := c.(*popper)
+	if p.DeadLetter == "" {
+		return nil, "", micro.Errorf(micro.CodeUnavailable, "mq.redis.deadLetter not configured")
+	}
+	pool, err := NewRedis("tcp", fmt.Sprintf("%s:%d", p.Server, p.Port), 1)
+	if err != nil {
+		return nil, "", micro.Errorf(micro.CodeUnavailable, "cannot connect to REDIS: %v", err)
+	}
+	return pool, p.DeadLetter, nil
+}
+
+//handleDeadLetters runs a management operation on the configured dead letter queue
+func handleDeadLetters(run func(pool Redis, qname string) (interface{}, error)) (interface{}, interface{}, error) {
+	pool, qname, err := deadLetters()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer pool.Close()
+	res, err := run(pool, qname)
+	return res, nil, err
+}
+
+//entry is a dead letter with the text stored in REDIS, needed to remove it
+type entry struct {
+	mq.DeadLetter
+	raw string
+}
+
+//find dead letters, all when id is empty, newest first
+func find(pool Redis, qname string, id string) ([]entry, error) {
+	values, err := pool.LRANGE(qname, 0, -1)
+	if err != nil {
+		return nil, micro.Errorf(micro.CodeUnavailable, "cannot read %s: %v", qname, err)
+	}
+	entries := make([]entry, 0)
+	for _, value := range values {
+		e := entry{raw: value}
+		if err := json.Unmarshal([]byte(value), &e.DeadLetter); err != nil {
+			log.Errorf("%s: invalid dead letter: %v", qname, err)
+			continue
+		}
+		if id == "" || e.ID == id {
+			entries = append(entries, e)
+		}
+	}
+	if id != "" && len(entries) == 0 {
+		return nil, micro.Errorf(micro.CodeNotFound, "dead letter %s not found", id)
+	}
+	return entries, nil
+}
+
+//dlqList lists dead letters
+type dlqList struct {
+	micro.Service
+	Offset int `json:"offset" doc:"Nr of newest dead letters to skip." validate:"min=0"`
+	Limit  int `json:"limit" doc:"Max nr of dead letters to list." default:"100" validate:"min=1,max=1000"`
+}
+
+func (oper *dlqList) Validate() error { return nil }
+
+func (oper dlqList) Describe() (interface{}, interface{}) { return []mq.DeadLetter{}, nil }
+
+func (oper dlqList) Handle() (interface{}, interface{}) { return micro.MustHandle(oper) }
+
+func (oper dlqList) HandleErr() (interface{}, interface{}, error) {
+	return handleDeadLetters(oper.run)
+}
+
+func (oper dlqList) run(pool Redis, qname string) (interface{}, error) {
+	values, err := pool.LRANGE(qname, oper.Offset, oper.Offset+oper.Limit-1)
+	if err != nil {
+		return nil, micro.Errorf(micro.CodeUnavailable, "cannot read %s: %v", qname, err)
+	}
+	list := make([]mq.DeadLetter, 0, len(values))
+	for _, value := range values {
+		dl := mq.DeadLetter{}
+		if err := json.Unmarshal([]byte(value), &dl); err != nil {
+			log.Errorf("%s: invalid dead letter: %v", qname, err)
+			continue
+		}
+		list = append(list, dl)
+	}
+	return list, nil
+}
+
+//dlqInspect gets one dead letter
+type dlqInspect struct {
+	micro.Service
+	ID string `json:"id" doc:"ID of the dead letter." validate:"required"`
+}
+
+func (oper *dlqInspect) Validate() error { return nil }
+
+func (oper dlqInspect) Describe() (interface{}, interface{}) { return mq.DeadLetter{}, nil }
+
+func (oper dlqInspect) Handle() (interface{}, interface{}) { return micro.MustHandle(oper) }
+
+func (oper dlqInspect) HandleErr() (interface{}, interface{}, error) {
+	return handleDeadLetters(oper.run)
+}
+
+func (oper dlqInspect) run(pool Redis, qname string) (interface{}, error) {
+	entries, err := find(pool, qname, oper.ID)
+	if err != nil {
+		return nil, err
+	}
+	return entries[0].DeadLetter, nil
+}
+
+//dlqReplay pushes dead letters back to the queue they came from
+type dlqReplay struct {
+	micro.Service
+	ID  string `json:"id" doc:"ID of the dead letter to replay."`
+	All bool   `json:"all" doc:"Replay all dead letters."`
+}
+
+type replayed struct {
+	Replayed int `json:"replayed"`
+}
+
+//Methods only allows POST because replay sends the messages again
+func (oper dlqReplay) Methods() []string { return []string{http.MethodPost} }
+
+func (oper *dlqReplay) Validate() error {
+	if (oper.ID == "") == !oper.All {
+		return micro.FieldErrors{{Field: "id", Message: "specify either id or all"}}
+	}
+	return nil
+}
+
+func (oper dlqReplay) Describe() (interface{}, interface{}) { return replayed{}, nil }
+
+func (oper dlqReplay) Handle() (interface{}, interface{}) { return micro.MustHandle(oper) }
+
+func (oper dlqReplay) HandleErr() (interface{}, interface{}, error) {
+	return handleDeadLetters(oper.run)
+}
+
+func (oper dlqReplay) run(pool Redis, qname string) (interface{}, error) {
+	entries, err := find(pool, qname, oper.ID)
+	if err != nil {
+		return nil, err
+	}
+	res := replayed{}
+	//replay oldest first to keep the original order
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if removed, err := pool.LREM(qname, 1, e.raw); err != nil || removed == 0 {
+			continue //replayed or purged by someone else
+		}
+		if err := requeue(pool, e.Queue, e.Payload); err != nil {
+			pool.LPUSH(qname, e.raw)
+			return res, micro.Errorf(micro.CodeUnavailable, "failed to replay %s to %s: %v", e.ID, e.Queue, err)
+		}
+		log.Infof("Replayed dead letter %s to %s", e.ID, e.Queue)
+		res.Replayed++
+	}
+	return res, nil
+}
+
+//requeue a message to a list, or to a stream in stream mode
+func requeue(pool Redis, queue string, payload string) error {
+	if t, err := pool.TYPE(queue); err == nil && t == "stream" {
+		_, err := pool.XADD(queue, map[string]interface{}{streamField: payload})
+		return err
+	}
+	return pool.LPUSH(queue, payload)
+}
+
+//dlqPurge deletes dead letters
+type dlqPurge struct {
+	micro.Service
+	ID  string `json:"id" doc:"ID of the dead letter to delete."`
+	All bool   `json:"all" doc:"Delete all dead letters."`
+}
+
+type purged struct {
+	Purged int `json:"purged"`
+}
+
+//Methods only allows POST because purge deletes dead letters
+func (oper dlqPurge) Methods() []string { return []string{http.MethodPost} }
+
+func (oper *dlqPurge) Validate() error {
+	if (oper.ID == "") == !oper.All {
+		return micro.FieldErrors{{Field: "id", Message: "specify either id or all"}}
+	}
+	return nil
+}
+
+func (oper dlqPurge) Describe() (interface{}, interface{}) { return purged{}, nil }
+
+func (oper dlqPurge) Handle() (interface{}, interface{}) { return micro.MustHandle(oper) }
+
+func (oper dlqPurge) HandleErr() (interface{}, interface{}, error) {
+	return handleDeadLetters(oper.run)
+}
+
+func (oper dlqPurge) run(pool Redis, qname string) (interface{}, error) {
+	entries, err := find(pool, qname, oper.ID)
+	if err != nil {
+		return nil, err
+	}
+	res := purged{}
+	for _, e := range entries {
+		if removed, err := pool.LREM(qname, 1, e.raw); err == nil {
+			res.Purged += removed
+		}
+	}
+	log.Infof("Purged %d dead letters from %s", res.Purged, qname)
+	return res, nil
+}
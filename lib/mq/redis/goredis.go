@@ -75,6 +75,24 @@ func (r GoRedis) BRPOP(queuename string, waitTime int) (string, error) {
 	return result.Val()[1], nil
 }
 
+//BRPOPLPUSH pops from source and pushes to destination, returns "" when timed out
+func (r GoRedis) BRPOPLPUSH(source string, destination string, waitTime int) (string, error) {
+	result := r.client.BRPopLPush(source, destination, time.Duration(waitTime)*time.Second)
+	if result.Err() == redis.Nil {
+		return "", nil
+	}
+	return result.Val(), errors.Wrap(result.Err(), "failed sending BRPOPLPUSH")
+}
+
+//RPOPLPUSH moves one value from source to destination, returns "" when source is empty
+func (r GoRedis) RPOPLPUSH(source string, destination string) (string, error) {
+	result := r.client.RPopLPush(source, destination)
+	if result.Err() == redis.Nil {
+		return "", nil
+	}
+	return result.Val(), errors.Wrap(result.Err(), "Failed to RPOPLPUSH")
+}
+
 // LPUSH ...
 func (r GoRedis) LPUSH(queuename string, value string) error {
 
@@ -116,6 +134,12 @@ func (r GoRedis) GET(key string) (string, error) {
 
 }
 
+//EXISTS ...
+func (r GoRedis) EXISTS(key string) (bool, error) {
+	cmd := r.client.Exists(key)
+	return cmd.Val() > 0, errors.Wrap(cmd.Err(), "Failed to EXISTS")
+}
+
 //DEL ..
 func (r GoRedis) DEL(key ...string) error {
 
@@ -133,6 +157,38 @@ func (r GoRedis) LLEN(queueName string) (int, error) {
 
 }
 
+//LRANGE ...
+func (r GoRedis) LRANGE(queueName string, start, stop int) ([]string, error) {
+
+	cmd := r.client.LRange(queueName, int64(start), int64(stop))
+
+	return cmd.Val(), errors.Wrap(cmd.Err(), "Failed to LRANGE")
+}
+
+//LREM ...
+func (r GoRedis) LREM(queueName string, count int, value string) (int, error) {
+
+	cmd := r.client.LRem(queueName, int64(count), value)
+
+	return int(cmd.Val()), errors.Wrap(cmd.Err(), "Failed to LREM")
+}
+
+//SADD ...
+func (r GoRedis) SADD(key string, member string) error {
+	return errors.Wrap(r.client.SAdd(key, member).Err(), "Failed to SADD")
+}
+
+//SREM ...
+func (r GoRedis) SREM(key string, member string) error {
+	return errors.Wrap(r.client.SRem(key, member).Err(), "Failed to SREM")
+}
+
+//SMEMBERS ...
+func (r GoRedis) SMEMBERS(key string) ([]string, error) {
+	cmd := r.client.SMembers(key)
+	return cmd.Val(), errors.Wrap(cmd.Err(), "Failed to SMEMBERS")
+}
+
 //SCAN ...
 func (r GoRedis) SCAN(args ...interface{}) (cursor int, values []string, err error) {
 
@@ -182,6 +238,97 @@ func (r GoRedis) SCAN(args ...interface{}) (cursor int, values []string, err err
 	return 0, nil, nil*/
 }
 
+//PING checks the connection to the server
+func (r GoRedis) PING() error {
+	return errors.Wrap(r.client.Ping().Err(), "Failed to PING")
+}
+
+//TYPE returns the type of value stored at key, or "none"
+func (r GoRedis) TYPE(key string) (string, error) {
+	cmd := r.client.Type(key)
+	return cmd.Val(), errors.Wrap(cmd.Err(), "Failed to TYPE")
+}
+
+//XADD appends a message to a stream and returns its ID
+func (r GoRedis) XADD(stream string, values map[string]interface{}) (string, error) {
+	cmd := r.client.XAdd(&redis.XAddArgs{Stream: stream, Values: values})
+	return cmd.Val(), errors.Wrap(cmd.Err(), "Failed to XADD")
+}
+
+//XGROUPCREATE creates a consumer group, and the stream if it does not exist
+func (r GoRedis) XGROUPCREATE(stream string, group string, start string) error {
+	return errors.Wrap(r.client.XGroupCreateMkStream(stream, group, start).Err(), "Failed to XGROUP CREATE")
+}
+
+//XGROUPDELCONSUMER removes a consumer from a group
+func (r GoRedis) XGROUPDELCONSUMER(stream string, group string, consumer string) error {
+	return errors.Wrap(r.client.XGroupDelConsumer(stream, group, consumer).Err(), "Failed to XGROUP DELCONSUMER")
+}
+
+//XREADGROUP reads the next new message for a consumer in a group,
+//returns nil when timed out
+func (r GoRedis) XREADGROUP(stream string, group string, consumer string, waitTime int) (*StreamEntry, error) {
+	result := r.client.XReadGroup(&redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{stream, ">"},
+		Count:    1,
+		Block:    time.Duration(waitTime) * time.Second,
+	})
+	if result.Err() == redis.Nil {
+		return nil, nil
+	} else if result.Err() != nil {
+		return nil, errors.Wrap(result.Err(), "failed sending XREADGROUP")
+	}
+	for _, s := range result.Val() {
+		for _, m := range s.Messages {
+			return &StreamEntry{ID: m.ID, Values: m.Values}, nil
+		}
+	}
+	return nil, nil
+}
+
+//XACK acknowledges messages processed by a group
+func (r GoRedis) XACK(stream string, group string, ids ...string) error {
+	return errors.Wrap(r.client.XAck(stream, group, ids...).Err(), "Failed to XACK")
+}
+
+//XDEL deletes messages from a stream
+func (r GoRedis) XDEL(stream string, ids ...string) error {
+	return errors.Wrap(r.client.XDel(stream, ids...).Err(), "Failed to XDEL")
+}
+
+//XPENDING lists messages that were not acknowledged, of all consumers when consumer is ""
+func (r GoRedis) XPENDING(stream string, group string, consumer string, count int) ([]PendingEntry, error) {
+	cmd := r.client.XPendingExt(&redis.XPendingExtArgs{Stream: stream, Group: group, Start: "-", End: "+", Count: int64(count), Consumer: consumer})
+	if cmd.Err() != nil {
+		return nil, errors.Wrap(cmd.Err(), "Failed to XPENDING")
+	}
+	entries := make([]PendingEntry, 0, len(cmd.Val()))
+	for _, p := range cmd.Val() {
+		entries = append(entries, PendingEntry{ID: p.Id, Consumer: p.Consumer, Idle: p.Idle})
+	}
+	return entries, nil
+}
+
+//XCLAIM takes over messages of other consumers that were pending for at least minIdle
+func (r GoRedis) XCLAIM(stream string, group string, consumer string, minIdle time.Duration, ids ...string) ([]StreamEntry, error) {
+	cmd := r.client.XClaim(&redis.XClaimArgs{Stream: stream, Group: group, Consumer: consumer, MinIdle: minIdle, Messages: ids})
+	if cmd.Err() != nil {
+		return nil, errors.Wrap(cmd.Err(), "Failed to XCLAIM")
+	}
+	entries := make([]StreamEntry, 0, len(cmd.Val()))
+	for _, m := range cmd.Val() {
+		entries = append(entries, StreamEntry{ID: m.ID, Values: m.Values})
+	}
+	return entries, nil
+}
+
+//Close the client and its connections
+func (r GoRedis) Close() error {
+	return r.client.Close()
+}
+
 //MaxActive ...
 func (r GoRedis) MaxActive() int {
 	return int(r.client.PoolStats().TotalConns)
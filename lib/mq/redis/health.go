@@ -0,0 +1,165 @@
+package redis
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jansemmelink/msf/lib/micro"
+)
+
+func init() {
+	micro.Domain("redis").AddName("health", &healthOper{})
+}
+
+//connection states reported by the health operation
+const (
+	stateConnecting   = "connecting"
+	stateConnected    = "connected"
+	stateReconnecting = "reconnecting"
+	stateStopped      = "stopped"
+)
+
+//status of a popper connection to REDIS
+type status struct {
+	Queue      string    `json:"queue"`
+	State      string    `json:"state"`
+	Since      time.Time `json:"since"`
+	Reconnects int       `json:"reconnects"`
+	LastError  string    `json:"lastError,omitempty"`
+
+	//workers processing popped messages
+	Workers   int   `json:"workers"`
+	Busy      int   `json:"busy"`
+	Processed int64 `json:"processed"`
+
+	//milliseconds from the request timestamp until it was popped
+	QueueWaitAvg float64 `json:"queueWaitAvgMs"`
+	QueueWaitMax float64 `json:"queueWaitMaxMs"`
+}
+
+//health is the status shared by all connections of a popper
+type health struct {
+	mutex     sync.Mutex
+	status    status
+	totalWait time.Duration
+	maxWait   time.Duration
+}
+
+var (
+	healthMutex = sync.Mutex{}
+	healths     = make(map[string]*health)
+)
+
+//newHealth registers the health of a popper by queue name,
+//replacing that of a previous popper on the same queue
+func newHealth(qname string) *health {
+	h := &health{status: status{Queue: qname, State: stateConnecting, Since: time.Now()}}
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+	healths[qname] = h
+	return h
+}
+
+//up marks the connection as working, counting a reconnect
+//if it was lost
+func (h *health) up() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.status.State == stateConnected {
+		return
+	}
+	if h.status.State == stateReconnecting {
+		h.status.Reconnects++
+	}
+	h.status.State = stateConnected
+	h.status.Since = time.Now()
+}
+
+//down marks the connection as lost
+func (h *health) down(err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.status.LastError = err.Error()
+	if h.status.State == stateConnected {
+		h.status.State = stateReconnecting
+		h.status.Since = time.Now()
+	}
+}
+
+func (h *health) stopped() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.status.State = stateStopped
+	h.status.Since = time.Now()
+}
+
+func (h *health) setWorkers(n int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.status.Workers = n
+}
+
+//busy counts workers that start (1) or end (-1) processing a message
+func (h *health) busy(n int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.status.Busy += n
+}
+
+//waited records how long a message waited in the queue
+func (h *health) waited(d time.Duration) {
+	if d < 0 {
+		d = 0 //clocks of producer and consumer differ
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.status.Processed++
+	h.totalWait += d
+	if d > h.maxWait {
+		h.maxWait = d
+	}
+}
+
+func (h *health) get() status {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	s := h.status
+	if s.Processed > 0 {
+		s.QueueWaitAvg = milliseconds(h.totalWait / time.Duration(s.Processed))
+	}
+	s.QueueWaitMax = milliseconds(h.maxWait)
+	return s
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+//healthOper reports the status of REDIS poppers
+type healthOper struct {
+	micro.Service
+	Queue string `json:"queue" doc:"Only report the popper of this queue."`
+}
+
+func (oper *healthOper) Validate() error { return nil }
+
+func (oper healthOper) Describe() (interface{}, interface{}) { return []status{}, nil }
+
+func (oper healthOper) Handle() (interface{}, interface{}) { return micro.MustHandle(oper) }
+
+func (oper healthOper) HandleErr() (interface{}, interface{}, error) {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+	list := make([]status, 0, len(healths))
+	for qname, h := range healths {
+		if oper.Queue == "" || oper.Queue == qname {
+			list = append(list, h.get())
+		}
+	}
+	if oper.Queue != "" && len(list) == 0 {
+		return nil, nil, micro.Errorf(micro.CodeNotFound, "no popper on queue %s", oper.Queue)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Queue < list[j].Queue })
+	return list, nil, nil
+}
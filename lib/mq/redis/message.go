@@ -0,0 +1,115 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jansemmelink/msf/lib/micro"
+)
+
+//Message is the JSON document sent through REDIS queues
+//Requests have a header without result and the request data,
+//responses have a header with a result and the response data.
+type Message struct {
+	Header   *Header         `json:"header"`
+	Request  json.RawMessage `json:"request,omitempty"`
+	Response interface{}     `json:"response,omitempty"`
+}
+
+//Header describes a message
+type Header struct {
+	IntGUID   string    `json:"int_guid" doc:"Unique ID of the request, copied to the response."`
+	Timestamp string    `json:"timestamp" doc:"Local time when the message was sent as \"YYYY-MM-DD HH:MM:SS.sss\"."`
+	TTL       int       `json:"ttl" doc:"Milliseconds after the timestamp that the request expires. When 0, the listener's ttl0 applies from when the request is popped (60000 by default), or the request is discarded when ttl0 is -1."`
+	Provider  *Provider `json:"provider" doc:"The requested operation."`
+	Consumer  *Consumer `json:"consumer,omitempty" doc:"Where to send the response. Omit if no response is required."`
+	Result    *Result   `json:"result,omitempty" doc:"Outcome of the request, present only in responses."`
+
+	ts time.Time
+}
+
+//TimestampFormat is the format of Header.Timestamp
+const TimestampFormat = "2006-01-02 15:04:05.000"
+
+//Provider names the requested operation as "/domain/oper"
+type Provider struct {
+	Name string `json:"name"`
+
+	domainPath string
+	operName   string
+}
+
+//Consumer names the queue where the response must be pushed
+type Consumer struct {
+	Name string `json:"name"`
+}
+
+//CodeOK is the result code of a successful response
+const CodeOK micro.Code = "ok"
+
+//Result is present in the header of responses only
+type Result struct {
+	Code    micro.Code  `json:"code"`
+	Message string      `json:"message,omitempty"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+//Decode a popped request message and check its header
+func Decode(data string) (*Message, error) {
+	msg := &Message{}
+	if err := json.Unmarshal([]byte(data), msg); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	h := msg.Header
+	if h == nil {
+		return nil, fmt.Errorf("missing header")
+	}
+	if len(h.IntGUID) == 0 {
+		return nil, fmt.Errorf("missing header.int_guid")
+	}
+	if len(h.Timestamp) == 0 {
+		return nil, fmt.Errorf("missing header.timestamp")
+	}
+	if len(h.Timestamp) == len("2017-06-07 11:37:58") {
+		h.Timestamp = h.Timestamp + ".000"
+	}
+	var err error
+	if h.ts, err = time.ParseInLocation(TimestampFormat, h.Timestamp, time.Local); err != nil {
+		return nil, fmt.Errorf("invalid header.timestamp: %v", err)
+	}
+	if h.Result != nil {
+		return nil, fmt.Errorf("header.result present in request")
+	}
+
+	//requests must have a valid provider name written as "/domain/oper"
+	if h.Provider == nil {
+		return nil, fmt.Errorf("missing header.provider")
+	}
+	i := strings.LastIndex(h.Provider.Name, "/")
+	if !strings.HasPrefix(h.Provider.Name, "/") || i == len(h.Provider.Name)-1 {
+		return nil, fmt.Errorf("header.provider.name=\"%s\" not /domain/oper", h.Provider.Name)
+	}
+	h.Provider.domainPath = h.Provider.Name[:i]
+	h.Provider.operName = h.Provider.Name[i+1:]
+	return msg, nil
+}
+
+//Reply makes the response message to a request
+func (msg Message) Reply(r micro.Result) Message {
+	res := &Result{Code: CodeOK}
+	if r.Err != nil {
+		res = &Result{Code: r.Err.Code, Message: r.Err.Message, Details: r.Err.Details}
+	}
+	return Message{
+		Header: &Header{
+			IntGUID:   msg.Header.IntGUID,
+			Timestamp: time.Now().Format(TimestampFormat),
+			Provider:  msg.Header.Provider,
+			Consumer:  msg.Header.Consumer,
+			Result:    res,
+		},
+		Response: r.Response,
+	}
+}
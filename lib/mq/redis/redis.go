@@ -1,8 +1,14 @@
 package redis
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jansemmelink/msf/lib/log"
@@ -17,15 +23,27 @@ func init() {
 
 type popper struct {
 	mq.Listener
-	Server        string `json:"server" doc:"REDIS Server address or hostname. Defaults to localhost."`
-	Port          int    `json:"port" doc:"REDIS Server TCP port number. Defaults to 6379."`
-	NrConn        int    `json:"nrConn" doc:"Nr of connections to make to the server. Defaults to 1."`
-	QName         string `json:"qname" doc:"REDIS queue name to consume."`
-	Limit         int    `json:"limit" doc:"Terminate after popping this nr of messages. Defaults to -1 = unlimited."`
-	MaxConcurrent int    `json:"maxConcurrent" doc:"Max concurrent transactions. Defaults to 100."`
+	Server          string `json:"server" doc:"REDIS Server address or hostname. Defaults to localhost."`
+	Port            int    `json:"port" doc:"REDIS Server TCP port number. Defaults to 6379."`
+	NrConn          int    `json:"nrConn" doc:"Nr of connections to make to the server. Defaults to 1."`
+	QName           string `json:"qname" doc:"REDIS queue name to consume."`
+	Mode            string `json:"mode" doc:"Consume a list (list) or a stream with a consumer group (stream). Defaults to list."`
+	Group           string `json:"group" doc:"Consumer group in stream mode. Defaults to the queue name."`
+	DeleteProcessed bool   `json:"deleteProcessed" doc:"Delete messages from the stream after they were processed in stream mode. Only use this when no other consumer group reads the stream, else trim the stream with XTRIM or XADD MAXLEN."`
+	Limit           int    `json:"limit" doc:"Terminate after popping this nr of messages. Defaults to -1 = unlimited."`
+	MaxConcurrent   int    `json:"maxConcurrent" doc:"Max concurrent transactions. Defaults to 100."`
+	TTL0            int    `json:"ttl0" doc:"Milliseconds allowed from when a request without header.ttl is popped. Defaults to 60000, or -1 to discard such requests."`
+	DeadLetter      string `json:"deadLetter" doc:"REDIS list where messages are pushed that could not be processed. Defaults to none, to discard them."`
+	ReconnectMin    int    `json:"reconnectMin" doc:"Milliseconds to wait before the first attempt to reconnect when the connection is lost. Defaults to 100."`
+	ReconnectMax    int    `json:"reconnectMax" doc:"Max milliseconds to wait between attempts to reconnect. Defaults to 30000."`
+	Reliable        bool   `json:"reliable" doc:"Keep popped messages in a processing list until processed, to recover them when the consumer crashed. Always the case in stream mode."`
+	Consumer        string `json:"consumer" doc:"Unique name of this consumer in reliable or stream mode. Defaults to <hostname>-<pid>."`
+	Visibility      int    `json:"visibility" doc:"Milliseconds after a consumer in reliable or stream mode stopped before its unprocessed messages are recovered. Defaults to 60000."`
 
 	//runtime data
-	remain int
+	remain    *int64
+	lastClaim *int64
+	health    *health
 }
 
 func (p *popper) Validate() error {
@@ -47,194 +65,351 @@ func (p *popper) Validate() error {
 	if p.MaxConcurrent < 1 {
 		p.MaxConcurrent = 100
 	}
+	if p.TTL0 == 0 {
+		p.TTL0 = 60000
+	}
+	if p.ReconnectMin <= 0 {
+		p.ReconnectMin = 100
+	}
+	if p.ReconnectMax <= 0 {
+		p.ReconnectMax = 30000
+	}
+	if p.ReconnectMax < p.ReconnectMin {
+		p.ReconnectMax = p.ReconnectMin
+	}
+	switch p.Mode {
+	case "":
+		p.Mode = modeList
+	case modeList:
+	case modeStream:
+		if p.Group == "" {
+			p.Group = p.QName
+		}
+	default:
+		return fmt.Errorf("mode=%s, expecting %s|%s", p.Mode, modeList, modeStream)
+	}
+	if p.Reliable || p.Mode == modeStream {
+		if p.Consumer == "" {
+			hostname, _ := os.Hostname()
+			p.Consumer = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+		}
+		if p.Visibility <= 0 {
+			p.Visibility = 60000
+		}
+	}
 	log.Debugf("popper validated: %+v", p)
 	return nil
 }
 
-//Listen ...
-func (p popper) Listen(d micro.IDomain) {
+//Listen pops until the limit is reached or stop is done,
+//then waits for the messages in progress to be processed
+func (p popper) Listen(stop context.Context, d micro.IDomain) error {
 	log.Debugf("REDIS Listening to %s ...", p.QName)
 	//	p := Popper{pool: nil, stopped: false, count: 0, limit: limit}
 
-	pool, err := NewRedis("tcp", fmt.Sprintf("%s:%d", p.Server, p.Port), p.NrConn)
+	//workers need connections to reply while the others are blocked in BRPOP
+	pool, err := NewRedis("tcp", fmt.Sprintf("%s:%d", p.Server, p.Port), p.NrConn+p.MaxConcurrent)
 	if err != nil {
-		panic(errors.Wrapf(err, "Failed to create Redis pool for pop"))
+		return errors.Wrapf(err, "Failed to create Redis pool for pop")
 	}
 
-	//atomic counter of the current nr of decoders running
-	//every decoder in the end result in another context being created
-	//(except in the case of response popper) so the nr of decoders
-	//are deducted from the capacity of the server
-	//note: without this, under load, messages are popped very fast and
-	//request decoders are spun up faster than they can complete and
-	//server capacity does not decrease, causing the popper to take on too much
-	//and use thousands of goroutines concurrently, eventually killing the server
-	//var nrDecoders int32 //atomic.Value
-	//nrDecoders = 0
+	p.health = newHealth(p.QName)
+	if err := pool.PING(); err != nil {
+		log.Errorf("Popper(%s): REDIS not available: %v", p.QName, err)
+		p.health.down(err)
+	} else {
+		p.health.up()
+	}
 
-	log.Debugf("Creating context channel...")
+	//each context is a worker that processes one popped message at a time
+	//and connections only pop when a context is available, so that popping
+	//slows down to the rate at which messages are processed instead of
+	//taking on more messages than the server can handle
+	log.Debugf("Creating %d workers...", p.MaxConcurrent)
 	ctxChannel := make(chan ctx, p.MaxConcurrent)
+	jobs := make(chan job)
+	workers := sync.WaitGroup{}
 	for i := 0; i < p.MaxConcurrent; i++ {
 		ctxChannel <- ctx{id: i}
-		log.Debugf("Added context[%d] ...", i)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for j := range jobs {
+				j.ctx.Process(pool, p, d, j.data)
+				p.ack(pool, j.id, j.data)
+				ctxChannel <- j.ctx
+			}
+		}()
+	}
+	p.health.setWorkers(p.MaxConcurrent)
+
+	//messages left by a previous run with the same consumer name
+	//must be back in the queue before popping into the processing list
+	if p.Reliable && p.Mode == modeList {
+		p.recover(pool, p.Consumer)
 	}
 
 	//start a go routine for each connection to pop messages
-	wg := sync.WaitGroup{}
-	p.remain = p.Limit
+	//a connection that fails with a command error, e.g. WRONGTYPE when the
+	//queue is not of the expected type, stops the popper because that
+	//will not be fixed by reconnecting
+	stop, fail := context.WithCancel(stop)
+	defer fail()
+	var failed error
+	failedOnce := sync.Once{}
+	conns := sync.WaitGroup{}
+	remain := int64(p.Limit)
+	p.remain = &remain
+	var lastClaim int64
+	p.lastClaim = &lastClaim
 	for i := 0; i < p.NrConn; i++ {
-		wg.Add(1)
+		conns.Add(1)
 		go func(conn int) {
-			p.pop(pool, conn, ctxChannel)
-			wg.Done()
+			if err := p.pop(stop, pool, conn, ctxChannel, jobs); err != nil {
+				failedOnce.Do(func() { failed = err })
+				fail()
+			}
+			conns.Done()
 		}(i)
 	}
-	//wait for all to terminate
-	wg.Wait()
+
+	//wait for all to terminate, and when stopped, only wait
+	//for messages in progress until the drain timeout
+	//in reliable mode, keep the heartbeat until messages in progress
+	//were processed, so that other consumers do not recover them
+	//the pool is only closed after the workers are done, even when
+	//they are still busy after the drain timeout, so that they can
+	//still reply and ack
+	heartbeatCtx, stopHeartbeat := context.WithCancel(context.Background())
+	heartbeatDone := make(chan struct{})
+	go func() {
+		if p.Reliable && p.Mode == modeList {
+			p.heartbeat(heartbeatCtx, pool)
+		}
+		close(heartbeatDone)
+	}()
+	terminated := make(chan struct{})
+	go func() {
+		conns.Wait()
+		close(jobs)
+		workers.Wait()
+		stopHeartbeat()
+		<-heartbeatDone
+		switch {
+		case p.Mode == modeStream:
+			p.leaveGroup(pool)
+		case p.Reliable:
+			p.unregister(pool)
+		}
+		p.health.stopped()
+		pool.Close()
+		close(terminated)
+	}()
+	select {
+	case <-terminated:
+	case <-stop.Done():
+		log.Infof("Popper(%s) stopping, waiting up to %v for messages in progress...", p.QName, p.DrainTimeout())
+		select {
+		case <-terminated:
+		case <-time.After(p.DrainTimeout()):
+			log.Errorf("Popper(%s) stopped with %d messages still in progress, they will be acknowledged when done", p.QName, p.health.get().Busy)
+			return fmt.Errorf("popper(%s) stopped with messages still in progress", p.QName)
+		}
+	}
 	log.Infof("Popper terminated")
+	return failed
 }
 
-func (p popper) pop(pool Redis, conn int, ctxPool chan ctx) {
+//pop messages and hand them to the workers until stop is done,
+//returns an error when the server rejected a command
+func (p popper) pop(stop context.Context, pool Redis, conn int, ctxPool chan ctx, jobs chan<- job) error {
 	for {
-		if p.Limit > 0 && p.remain <= 0 {
-			log.Debugf("Popper(%s) conn[%d] terminating after %d pops.", p.QName, conn, p.Limit)
+		if stop.Err() != nil {
 			break
 		}
 
 		//wait for and get next available context
-		//or terminate when the channel is closed
 		timeout := time.Duration(10) * time.Second
+		var ctx ctx
 		select {
-		case ctx := <-ctxPool:
+		case ctx = <-ctxPool:
 			log.Debugf("Conn[%d]: Got context: %+v", conn, ctx)
-			ctx.Pop(pool, p.QName, ctxPool)
+		case <-stop.Done():
+			continue
 		case <-time.After(timeout):
 			log.Errorf("Popper(%s) conn[%d]: No available contexts...", p.QName, conn)
+			continue
 		}
+
+		if !p.reserve() {
+			ctxPool <- ctx
+			log.Debugf("Popper(%s) conn[%d] terminating after %d pops.", p.QName, conn, p.Limit)
+			break
+		}
+		id, data, err := p.next(pool)
+		if err != nil || len(data) <= 0 {
+			//put context back in the pool when the connection was lost
+			//or the blocking pop timed out because the queue is idle
+			ctxPool <- ctx
+			p.release()
+			if err == nil {
+				continue
+			}
+			if poolTimeout(err) {
+				log.Errorf("Popper(%s) conn[%d]: %v", p.QName, conn, err)
+				continue
+			}
+			if !connectionLost(err) {
+				log.Errorf("Popper(%s) conn[%d]: failed: %v", p.QName, conn, err)
+				p.health.down(err)
+				return errors.Wrapf(err, "popper(%s) failed", p.QName)
+			}
+			if !p.reconnect(stop, pool, conn, err) {
+				break
+			}
+			continue
+		}
+		log.Tracef("%s: popped: %v", p.QName, data)
+		jobs <- job{ctx: ctx, id: id, data: data}
 	} //until stop
 	log.Debugf("Popper(%s) conn[%d]: Stopped", p.QName, conn)
+	return nil
+}
+
+//reserve one of the remaining pops when there is a limit,
+//returns false when the limit is reached
+func (p popper) reserve() bool {
+	if p.Limit < 0 {
+		return true
+	}
+	for {
+		remain := atomic.LoadInt64(p.remain)
+		if remain <= 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(p.remain, remain, remain-1) {
+			return true
+		}
+	}
+}
+
+//release a reserved pop when nothing was popped
+func (p popper) release() {
+	if p.Limit > 0 {
+		atomic.AddInt64(p.remain, 1)
+	}
+}
+
+//expiry policy of popped requests
+func (p popper) expiry() mq.Expiry {
+	if p.TTL0 < 0 {
+		return mq.Expiry{}
+	}
+	return mq.Expiry{TTL0: time.Duration(p.TTL0) * time.Millisecond}
+}
+
+//connectionLost is true for network errors, rather than errors
+//returned by the server for a command, e.g. WRONGTYPE or NOGROUP
+func connectionLost(err error) bool {
+	cause := errors.Cause(err)
+	if cause == io.EOF || cause == io.ErrUnexpectedEOF {
+		return true
+	}
+	_, ok := cause.(net.Error)
+	return ok
 }
 
+//poolTimeout is true when no connection of the pool became available
+//in time, which is not a network error, so the pop is simply retried
+func poolTimeout(err error) bool {
+	return errors.Cause(err).Error() == "redis: connection pool timeout"
+}
+
+//reconnect after the connection was lost, waiting longer between
+//attempts, until the server responds or stop is done
+//returns false when stopped
+func (p popper) reconnect(stop context.Context, pool Redis, conn int, err error) bool {
+	log.Errorf("Popper(%s) conn[%d]: connection lost: %v", p.QName, conn, err)
+	p.health.down(err)
+	backoff := mq.Backoff{
+		Min: time.Duration(p.ReconnectMin) * time.Millisecond,
+		Max: time.Duration(p.ReconnectMax) * time.Millisecond,
+	}
+	for backoff.Wait(stop) {
+		if err := pool.PING(); err != nil {
+			log.Debugf("Popper(%s) conn[%d]: reconnect failed: %v", p.QName, conn, err)
+			p.health.down(err)
+			continue
+		}
+		log.Infof("Popper(%s) conn[%d]: reconnected", p.QName, conn)
+		p.health.up()
+		return true
+	}
+	return false
+}
+
+//ctx is a worker that processes popped messages one at a time
 type ctx struct {
 	id int
 }
 
-func (ctx ctx) Pop(pool Redis, qname string, ctxPool chan ctx) int {
-	defer func() {
-		//put context back in the pool
-		ctxPool <- ctx
-	}()
+//job is a popped message for a worker
+type job struct {
+	ctx  ctx
+	id   string
+	data string
+}
+
+//Process a popped message and reply to the consumer, if any
+func (ctx ctx) Process(pool Redis, p popper, d micro.IDomain, data string) {
+	qname := p.QName
+	p.health.busy(1)
+	defer p.health.busy(-1)
 
-	data, err := pool.BRPOP(qname, 1)
+	msg, err := Decode(data)
 	if err != nil {
-		if err.Error() == "EOF" {
-			panic("%s: Redis Connection dropped: " + err.Error()) //TODO: Handle with re-connect
+		log.Errorf("%s: Discard: %v: %v", qname, err, data)
+		p.deadLetter(pool, err.Error(), data)
+		return
+	}
+	p.health.waited(time.Since(msg.Header.ts))
+
+	//discard requests that expired while waiting in the queue
+	//and let the handler know when the request expires
+	reqCtx, cancel, err := p.expiry().Context("redis:"+qname, msg.Header.Provider.Name, msg.Header.IntGUID, msg.Header.ts, time.Duration(msg.Header.TTL)*time.Millisecond)
+	if err != nil {
+		log.Errorf("%s: Discard: %s(%s): %v", qname, msg.Header.Provider.Name, msg.Header.IntGUID, err)
+		p.deadLetter(pool, err.Error(), data)
+		return
+	}
+	defer cancel()
+
+	result := micro.Invoke(d, micro.Request{
+		Path:    msg.Header.Provider.domainPath,
+		Oper:    msg.Header.Provider.operName,
+		Data:    msg.Request,
+		Context: reqCtx,
+	})
+	if result.Err != nil {
+		log.Errorf("%s: %s failed: %v", qname, msg.Header.Provider.Name, result.Err)
+		if result.Err.Code == micro.CodeInternal {
+			p.deadLetter(pool, result.Err.Error(), data)
 		}
-		log.Errorf("%s: BRPOP Error: %v", qname, err)
-		return 0
-	}
-
-	if len(data) <= 0 {
-		//blocking popped timed out - queue is idle
-		return 0
-	}
-
-	//popped a message
-	log.Errorf("%+v: NOT YET PROCESSING: %v", ctx, data)
-	return 1
-
-	//decode and handle in a separate go-routine
-	//so that this routine can immediately pop again
-	// go func() {
-	// 	atomic.AddInt32(&nrDecoders, 1)
-	// 	defer atomic.AddInt32(&nrDecoders, -1)
-
-	// 	messageHeader := MessageHeader{}
-	// 	messageHeader.json = data
-	// 	if err := messageHeader.FromJSON(data); err != nil {
-	// 		log.Error.Printf("%s: Discard: Invalid JSON: %v: %v", qname, err, data)
-	// 		return
-	// 	}
-
-	// 	if messageHeader.Header == nil {
-	// 		log.Error.Printf("%s: Discard: Missing header in %v", qname, data)
-	// 		return
-	// 	}
-
-	// 	if len(messageHeader.Header.IntGUID) == 0 {
-	// 		log.Error.Printf("%s: Discard: Missing header.int_guid in %v", qname, data)
-	// 		return
-	// 	}
-
-	// 	if len(messageHeader.Header.Timestamp) == 0 {
-	// 		log.Error.Printf("%s: Discard: Missing header.timestamp in %v", qname, data)
-	// 		return
-	// 	}
-
-	// 	if len(messageHeader.Header.Timestamp) == len("2017-06-07 11:37:58") {
-	// 		messageHeader.Header.Timestamp = messageHeader.Header.Timestamp + ".000"
-	// 	}
-
-	// 	var err error
-	// 	if messageHeader.Header._ts, err = time.ParseInLocation("2006-01-02 15:04:05.000", messageHeader.Header.Timestamp, time.Local); err != nil {
-	// 		log.Error.Printf("%s: Discard: Invalid header.timestamp %v: %v", qname, err, data)
-	// 		return
-	// 	}
-
-	// 	if messageHeader.Header.TTL < 0 {
-	// 		log.Error.Printf("%s: Discard: Negative Ttl: %v", qname, data)
-	// 		return
-	// 	}
-
-	// 	//zero TTL is used for messages that should not expire
-	// 	//they can sit long in a queue, but now that we popped the messageHeader,
-	// 	//we give it a sensible TTL starting from now + maxDuration in this process
-	// 	//so we use TTL = timeNow + TTL0 - timestamp
-	// 	if messageHeader.Header.TTL == 0 {
-	// 		if c.Pop.TTL0 == 0 {
-	// 			log.Error.Printf("%s: Discard: Zero Ttl (TTL0 not configured): %v", qname, data)
-	// 			return
-	// 		}
-	// 		messageHeader.Header.TTL = int(time.Now().Add(time.Duration(c.Pop.TTL0)*time.Millisecond).Sub(messageHeader.Header._ts) / time.Millisecond)
-	// 	}
-
-	// 	messageHeader.Header._ttl = time.Duration(messageHeader.Header.TTL) * time.Millisecond
-	// 	messageHeader.Header._expTime = messageHeader.Header._ts.Add(messageHeader.Header._ttl)
-
-	// 	//set default empty provider|consumer when not specified to require fewer checks later
-	// 	if messageHeader.Header.Provider == nil {
-	// 		messageHeader.Header.Provider = &Provider{}
-	// 	}
-	// 	if messageHeader.Header.Consumer == nil {
-	// 		messageHeader.Header.Consumer = &Consumer{}
-	// 	}
-
-	// 	//requests (messages without header.result) must have a valid provider name
-	// 	//written as "/domain/oper"
-	// 	if messageHeader.Header.Result == nil {
-	// 		do := strings.Split(messageHeader.Header.Provider.Name, "/")
-	// 		if len(do) != 3 {
-	// 			log.Error.Printf("%s: Discard: Provider.Name=\"%v\" not /domain/oper", qname, messageHeader.Header.Provider.Name)
-	// 			return
-	// 		}
-	// 		messageHeader.Header.Provider._domainName = do[1]
-	// 		messageHeader.Header.Provider._operName = do[2]
-	// 	} //if request
-
-	// 	// push into messageHeader channel then return
-	// 	log.Trace.Printf("%s: popped: %v", qname, data)
-	// 	log.Trace.Printf("          header=%v", *messageHeader.Header)
-	// 	log.Trace.Printf("          provider=%v", *messageHeader.Header.Provider)
-	// 	if messageHeader.Header.Consumer != nil {
-	// 		log.Trace.Printf("          consumer=%v", *messageHeader.Header.Consumer)
-	// 	}
-	// 	if messageHeader.Header.Result != nil {
-	// 		log.Trace.Printf("          result=%v", *messageHeader.Header.Result)
-	// 	}
-
-	// 	//send to the msgChannel
-	// 	//(blocking if necessary, i.e. do not use select{} around this)
-	// 	msgChannel <- messageHeader
-	// 	return
+	}
 
+	//send the response to the consumer, if any
+	if msg.Header.Consumer == nil || msg.Header.Consumer.Name == "" {
+		log.Debugf("%s: %s -> %+v (no consumer)", qname, msg.Header.Provider.Name, result.Response)
+		return
+	}
+	reply, err := json.Marshal(msg.Reply(result))
+	if err != nil {
+		log.Errorf("%s: Failed to encode response to %s: %v", qname, msg.Header.Consumer.Name, err)
+		return
+	}
+	if err := pool.LPUSH(msg.Header.Consumer.Name, string(reply)); err != nil {
+		log.Errorf("%s: Failed to reply to %s: %v", qname, msg.Header.Consumer.Name, err)
+		return
+	}
+	log.Debugf("%s: replied to %s: %s", qname, msg.Header.Consumer.Name, reply)
 }
@@ -0,0 +1,555 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jansemmelink/msf/lib/micro"
+	"github.com/jansemmelink/msf/lib/mq"
+	"github.com/pkg/errors"
+)
+
+type echo struct {
+	micro.Service
+	Text string `json:"text" validate:"required"`
+}
+
+func (e *echo) Validate() error { return nil }
+
+func (e echo) Handle() (interface{}, interface{}) { return e.Text, nil }
+
+type crash struct {
+	echo
+}
+
+func (c crash) Handle() (interface{}, interface{}) {
+	if c.Text == "boom" {
+		panic("crash")
+	}
+	return nil, nil
+}
+
+type slow struct {
+	echo
+}
+
+func (s slow) Handle() (interface{}, interface{}) {
+	if s.Text != "x" {
+		time.Sleep(200 * time.Millisecond)
+	}
+	return s.Text, nil
+}
+
+func init() {
+	micro.Domain("test").AddName("echo", &echo{})
+	micro.Domain("test").AddName("crash", &crash{echo{Text: "x"}})
+	micro.Domain("test").AddName("slow", &slow{echo{Text: "x"}})
+}
+
+//startPopper runs a popper against an in-process REDIS server
+func startPopper(t *testing.T, p *popper) (Redis, func()) {
+	return runPopper(t, miniredis.RunT(t), p)
+}
+
+//runPopper runs a popper against the given in-process REDIS server
+func runPopper(t *testing.T, server *miniredis.Miniredis, p *popper) (Redis, func()) {
+	hostPort := strings.Split(server.Addr(), ":")
+	p.Server = hostPort[0]
+	p.Port, _ = strconv.Atoi(hostPort[1])
+	if err := p.Validate(); err != nil {
+		t.Fatalf("invalid popper: %v", err)
+	}
+	client, _ := NewRedis("tcp", server.Addr(), 1)
+	t.Cleanup(func() { client.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() {
+		stopped <- p.Listen(ctx, micro.Root())
+	}()
+	return client, func() {
+		cancel()
+		if err := <-stopped; err != nil {
+			t.Errorf("Listen failed: %v", err)
+		}
+	}
+}
+
+func request(guid string, provider string, data string) string {
+	return requestAt(guid, provider, data, time.Now(), 0)
+}
+
+func requestAt(guid string, provider string, data string, ts time.Time, ttl int) string {
+	return fmt.Sprintf(`{"header":{"int_guid":"%s","timestamp":"%s","ttl":%d,"provider":{"name":"%s"},"consumer":{"name":"Q:reply"}},"request":%s}`,
+		guid, ts.Format(TimestampFormat), ttl, provider, data)
+}
+
+func TestRequestResponse(t *testing.T) {
+	client, stop := startPopper(t, &popper{QName: "Q:test"})
+	defer stop()
+
+	client.LPUSH("Q:test", request("1", "/test/echo", `{"text":"hello"}`))
+	client.LPUSH("Q:test", request("2", "/test/echo", `{}`))
+	client.LPUSH("Q:test", request("3", "/test/unknown", `{}`))
+
+	expected := map[string]micro.Code{"1": CodeOK, "2": micro.CodeInvalid, "3": micro.CodeNotFound}
+	for range expected {
+		data, err := client.BRPOP("Q:reply", 5)
+		if err != nil || data == "" {
+			t.Fatalf("no reply: %v", err)
+		}
+		reply := Message{}
+		if err := json.Unmarshal([]byte(data), &reply); err != nil || reply.Header == nil || reply.Header.Result == nil {
+			t.Fatalf("invalid reply: %v: %s", err, data)
+		}
+		if reply.Header.Result.Code != expected[reply.Header.IntGUID] {
+			t.Errorf("reply %s: code %s != %s", reply.Header.IntGUID, reply.Header.Result.Code, expected[reply.Header.IntGUID])
+		}
+		if reply.Header.IntGUID == "1" && reply.Response != "hello" {
+			t.Errorf("reply %s: wrong response: %+v", reply.Header.IntGUID, reply.Response)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	for _, data := range []string{
+		`not json`,
+		`{"request":{}}`,
+		`{"header":{"timestamp":"2019-01-02 03:04:05","provider":{"name":"/a/b"}}}`,
+		`{"header":{"int_guid":"1","provider":{"name":"/a/b"}}}`,
+		`{"header":{"int_guid":"1","timestamp":"yesterday","provider":{"name":"/a/b"}}}`,
+		`{"header":{"int_guid":"1","timestamp":"2019-01-02 03:04:05"}}`,
+		`{"header":{"int_guid":"1","timestamp":"2019-01-02 03:04:05","provider":{"name":"a"}}}`,
+	} {
+		if _, err := Decode(data); err == nil {
+			t.Errorf("Decode(%s) did not fail", data)
+		}
+	}
+	msg, err := Decode(`{"header":{"int_guid":"1","timestamp":"2019-01-02 03:04:05","provider":{"name":"/a/b/c"}}}`)
+	if err != nil || msg.Header.Provider.domainPath != "/a/b" || msg.Header.Provider.operName != "c" {
+		t.Errorf("Decode failed: %v", err)
+	}
+}
+
+func TestExpired(t *testing.T) {
+	client, stop := startPopper(t, &popper{QName: "Q:test"})
+	defer stop()
+
+	client.LPUSH("Q:test", requestAt("1", "/test/echo", `{"text":"late"}`, time.Now().Add(-time.Minute), 1000))
+	client.LPUSH("Q:test", requestAt("2", "/test/echo", `{"text":"-1"}`, time.Now(), -1))
+	client.LPUSH("Q:test", requestAt("3", "/test/echo", `{"text":"early"}`, time.Now(), 10000))
+	data, err := client.BRPOP("Q:reply", 5)
+	if err != nil || data == "" {
+		t.Fatalf("no reply: %v", err)
+	}
+	reply := Message{}
+	json.Unmarshal([]byte(data), &reply)
+	if reply.Header == nil || reply.Header.IntGUID != "3" {
+		t.Fatalf("expected only reply to 3, got %s", data)
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	p := &popper{QName: "Q:test", DeadLetter: "Q:dead", MaxConcurrent: 1} //in order
+	client, stop := startPopper(t, p)
+
+	client.LPUSH("Q:test", "not json")
+	client.LPUSH("Q:test", request("1", "/test/crash", `{"text":"boom"}`))
+	client.LPUSH("Q:test", request("2", "/test/echo", `{"text":"ok"}`))
+	client.BRPOP("Q:reply", 5)
+	if data, err := client.BRPOP("Q:reply", 5); err != nil || !strings.Contains(data, `"int_guid":"2"`) {
+		t.Fatalf("no reply to 2: %v %s", err, data)
+	}
+	stop()
+
+	list, err := dlqList{Limit: 10}.run(client, p.DeadLetter)
+	if err != nil || len(list.([]mq.DeadLetter)) != 2 {
+		t.Fatalf("wrong list: %v %+v", err, list)
+	}
+	dls := list.([]mq.DeadLetter)
+	if dls[1].Payload != "not json" || dls[1].Queue != "Q:test" || !strings.Contains(dls[0].Reason, "internal") {
+		t.Fatalf("wrong dead letters: %+v", dls)
+	}
+	if _, err := (dlqInspect{ID: "unknown"}).run(client, p.DeadLetter); err == nil {
+		t.Fatalf("inspect unknown did not fail")
+	}
+	if res, err := (dlqReplay{ID: dls[1].ID}).run(client, p.DeadLetter); err != nil || res.(replayed).Replayed != 1 {
+		t.Fatalf("replay failed: %v %+v", err, res)
+	}
+	if data, _ := client.BRPOP("Q:test", 1); data != "not json" {
+		t.Fatalf("not replayed: %s", data)
+	}
+	if res, err := (dlqPurge{All: true}).run(client, p.DeadLetter); err != nil || res.(purged).Purged != 1 {
+		t.Fatalf("purge failed: %v %+v", err, res)
+	}
+	if n, _ := client.LLEN(p.DeadLetter); n != 0 {
+		t.Fatalf("%d dead letters after purge", n)
+	}
+}
+
+func TestDeadLetterRequests(t *testing.T) {
+	for _, data := range []string{`{"limit":-1}`, `{"offset":-1}`, `{"limit":1001}`} {
+		res := micro.Invoke(micro.Root(), micro.Request{Path: "/redis/deadletter", Oper: "list", Data: []byte(data)})
+		if res.Err == nil || res.Err.Code != micro.CodeInvalid {
+			t.Errorf("list %s not invalid: %+v", data, res)
+		}
+	}
+	//replay and purge change the dead letter queue, so must not be allowed with GET
+	for _, name := range []string{"replay", "purge"} {
+		m, ok := micro.Domain("redis").Sub("deadletter").Get(name).(interface{ Methods() []string })
+		if !ok || len(m.Methods()) != 1 || m.Methods()[0] != http.MethodPost {
+			t.Errorf("%s is not POST only", name)
+		}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	server := miniredis.RunT(t)
+	client, stop := runPopper(t, server, &popper{QName: "Q:reconnect", ReconnectMin: 10, ReconnectMax: 50})
+	defer stop()
+
+	waitState := func(state string) status {
+		for i := 0; i < 100; i++ {
+			if s, _, _ := (healthOper{Queue: "Q:reconnect"}).HandleErr(); s != nil && s.([]status)[0].State == state {
+				return s.([]status)[0]
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatalf("popper not %s", state)
+		return status{}
+	}
+	waitState(stateConnected)
+
+	//restart on the same address (miniredis.Restart() breaks blocking commands)
+	addr := server.Addr()
+	server.Close()
+	if s := waitState(stateReconnecting); s.LastError == "" {
+		t.Errorf("no error reported: %+v", s)
+	}
+	server = miniredis.NewMiniRedis()
+	if err := server.StartAddr(addr); err != nil {
+		t.Fatalf("cannot restart REDIS: %v", err)
+	}
+	defer server.Close()
+	if s := waitState(stateConnected); s.Reconnects != 1 {
+		t.Errorf("expected 1 reconnect: %+v", s)
+	}
+
+	client.LPUSH("Q:reconnect", request("1", "/test/echo", `{"text":"again"}`))
+	if data, err := client.BRPOP("Q:reply", 5); err != nil || data == "" {
+		t.Fatalf("no reply after reconnect: %v", err)
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	client, stop := startPopper(t, &popper{QName: "Q:slow", MaxConcurrent: 5})
+	defer stop()
+
+	//5 workers process 10 messages taking 200ms each in about 400ms
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		client.LPUSH("Q:slow", request(fmt.Sprint(i), "/test/slow", `{"text":"zzz"}`))
+	}
+	busy := 0
+	for i := 0; i < 10; i++ {
+		if s, _, _ := (healthOper{Queue: "Q:slow"}).HandleErr(); s.([]status)[0].Busy > busy {
+			busy = s.([]status)[0].Busy
+		}
+		if data, err := client.BRPOP("Q:reply", 5); err != nil || data == "" {
+			t.Fatalf("no reply: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("not processed concurrently: %v", elapsed)
+	}
+	s, _, _ := (healthOper{Queue: "Q:slow"}).HandleErr()
+	if busy < 1 || busy > 5 || s.([]status)[0].Workers != 5 || s.([]status)[0].Processed != 10 {
+		t.Errorf("busy=%d, status: %+v", busy, s)
+	}
+}
+
+func TestLimit(t *testing.T) {
+	server := miniredis.RunT(t)
+	client, _ := NewRedis("tcp", server.Addr(), 1)
+	defer client.Close()
+	for i := 0; i < 5; i++ {
+		client.LPUSH("Q:limit", request(fmt.Sprint(i), "/test/slow", `{"text":"zzz"}`))
+	}
+
+	//stops by itself after processing the limit, shared by all connections
+	hostPort := strings.Split(server.Addr(), ":")
+	p := &popper{Server: hostPort[0], QName: "Q:limit", NrConn: 3, Limit: 3}
+	p.Port, _ = strconv.Atoi(hostPort[1])
+	p.Validate()
+	stopped := make(chan error)
+	go func() {
+		stopped <- p.Listen(context.Background(), micro.Root())
+	}()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatalf("Listen failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Listen did not stop after the limit")
+	}
+
+	//in-flight messages were processed before Listen returned
+	if n, _ := client.LLEN("Q:reply"); n != 3 {
+		t.Errorf("%d replies instead of 3", n)
+	}
+	if n, _ := client.LLEN("Q:limit"); n != 2 {
+		t.Errorf("%d messages left instead of 2", n)
+	}
+}
+
+func TestReliable(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	//messages left by a consumer that crashed are recovered, but not those
+	//of a consumer that is still alive
+	server.Lpush("Q:rel:processing:crashed", request("1", "/test/echo", `{"text":"recovered"}`))
+	server.SetAdd("Q:rel:consumers", "crashed", "alive")
+	server.Lpush("Q:rel:processing:alive", request("2", "/test/echo", `{"text":"busy"}`))
+	server.Set("Q:rel:consumer:alive", "now")
+
+	p := &popper{QName: "Q:rel", Reliable: true, Consumer: "me", Visibility: 300}
+	client, stop := runPopper(t, server, p)
+	data, err := client.BRPOP("Q:reply", 5)
+	if err != nil || !strings.Contains(data, "recovered") {
+		t.Fatalf("not recovered: %v: %s", err, data)
+	}
+	for i := 0; ; i++ {
+		if consumers, _ := server.Members("Q:rel:consumers"); strings.Join(consumers, ",") == "alive,me" {
+			break
+		} else if i == 100 {
+			t.Fatalf("consumers: %v", consumers)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n, _ := client.LLEN("Q:rel:processing:alive"); n != 1 {
+		t.Errorf("recovered messages of a live consumer")
+	}
+	if ttl := server.TTL("Q:rel:consumer:me"); ttl != time.Second {
+		t.Errorf("heartbeat ttl %v", ttl)
+	}
+
+	//processed messages are removed from the processing list
+	client.LPUSH("Q:rel", request("3", "/test/echo", `{"text":"hello"}`))
+	if data, err := client.BRPOP("Q:reply", 5); err != nil || data == "" {
+		t.Fatalf("no reply: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n, _ := client.LLEN("Q:rel:processing:me"); n != 0 {
+		t.Errorf("%d messages not acknowledged", n)
+	}
+
+	//unregistered when stopped
+	stop()
+	if consumers, _ := server.Members("Q:rel:consumers"); strings.Join(consumers, ",") != "alive" {
+		t.Errorf("consumers after stop: %v", consumers)
+	}
+}
+
+func TestReliableOnce(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	//messages left by a previous run of this consumer and new messages
+	//are each processed once while several connections pop
+	for i := 0; i < 10; i++ {
+		server.Lpush("Q:once:processing:me", request(fmt.Sprint("left", i), "/test/echo", `{"text":"left"}`))
+		server.Lpush("Q:once", request(fmt.Sprint("new", i), "/test/echo", `{"text":"new"}`))
+	}
+	p := &popper{QName: "Q:once", Reliable: true, Consumer: "me", Visibility: 300, NrConn: 3, MaxConcurrent: 3}
+	client, stop := runPopper(t, server, p)
+	defer stop()
+
+	replies := map[string]int{}
+	for i := 0; i < 20; i++ {
+		data, err := client.BRPOP("Q:reply", 5)
+		if err != nil || data == "" {
+			t.Fatalf("%d replies: %v", i, err)
+		}
+		var res struct {
+			Header struct {
+				IntGUID string `json:"int_guid"`
+			} `json:"header"`
+		}
+		json.Unmarshal([]byte(data), &res)
+		replies[res.Header.IntGUID]++
+	}
+	//give the heartbeat time to run and recover anything again
+	time.Sleep(250 * time.Millisecond)
+	if n, _ := client.LLEN("Q:reply"); n != 0 {
+		t.Errorf("%d more replies", n)
+	}
+	for guid, n := range replies {
+		if n != 1 {
+			t.Errorf("%s processed %d times", guid, n)
+		}
+	}
+	if len(replies) != 20 {
+		t.Errorf("%d messages processed instead of 20", len(replies))
+	}
+}
+
+func TestStream(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	//a message of a consumer that crashed is claimed after the visibility timeout
+	crashed, _ := NewRedis("tcp", server.Addr(), 1)
+	defer crashed.Close()
+	crashed.XGROUPCREATE("S:test", "workers", "0")
+	crashed.XADD("S:test", map[string]interface{}{streamField: request("1", "/test/echo", `{"text":"claimed"}`)})
+	if entry, err := crashed.XREADGROUP("S:test", "workers", "crashed", 1); err != nil || entry == nil {
+		t.Fatalf("not read: %v", err)
+	}
+
+	p := &popper{QName: "S:test", Mode: modeStream, Group: "workers", Consumer: "me", Visibility: 100, NrConn: 2}
+	client, stop := runPopper(t, server, p)
+	server.XAdd("S:test", "*", []string{streamField, request("2", "/test/echo", `{"text":"new"}`)})
+	server.XAdd("S:test", "*", []string{"other", "not a message"})
+
+	replies := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		data, err := client.BRPOP("Q:reply", 5)
+		if err != nil || data == "" {
+			t.Fatalf("no reply: %v", err)
+		}
+		reply := Message{}
+		json.Unmarshal([]byte(data), &reply)
+		replies[reply.Response.(string)] = true
+	}
+	if !replies["claimed"] || !replies["new"] {
+		t.Errorf("wrong replies: %v", replies)
+	}
+
+	//all acknowledged, and the consumer left the group when stopped
+	time.Sleep(50 * time.Millisecond)
+	if pending, _ := client.XPENDING("S:test", "workers", "", 10); len(pending) != 0 {
+		t.Errorf("not acknowledged: %+v", pending)
+	}
+	if entries, _ := server.Stream("S:test"); len(entries) != 3 {
+		t.Errorf("processed messages deleted: %+v", entries)
+	}
+	stop()
+	if pending, _ := client.XPENDING("S:test", "workers", "me", 10); len(pending) != 0 {
+		t.Errorf("pending after stop: %+v", pending)
+	}
+}
+
+func TestStreamGroup(t *testing.T) {
+	//the group is created when it does not exist
+	p := &popper{QName: "S:new", Mode: modeStream}
+	client, stop := startPopper(t, p)
+	defer stop()
+	if p.Group != "S:new" || p.Consumer == "" {
+		t.Errorf("no defaults: %+v", p)
+	}
+	time.Sleep(100 * time.Millisecond)
+	client.XADD("S:new", map[string]interface{}{streamField: request("1", "/test/echo", `{"text":"hello"}`)})
+	if data, err := client.BRPOP("Q:reply", 5); err != nil || !strings.Contains(data, "hello") {
+		t.Fatalf("no reply: %v %s", err, data)
+	}
+}
+
+func TestStreamClient(t *testing.T) {
+	server := miniredis.RunT(t)
+	_, stop := runPopper(t, server, &popper{QName: "S:client", Mode: modeStream, DeleteProcessed: true})
+	defer stop()
+	c, err := NewStreamClient(server.Addr(), "S:client")
+	if err != nil {
+		t.Fatalf("no client: %v", err)
+	}
+	defer c.Close()
+
+	var text string
+	if err := c.Call(context.Background(), "/test/echo", echo{Text: "hello"}, &text); err != nil || text != "hello" {
+		t.Fatalf("call failed: %v %s", err, text)
+	}
+	err = c.Call(context.Background(), "/test/echo", echo{}, &text)
+	if e, ok := err.(*micro.Error); !ok || e.Code != micro.CodeInvalid {
+		t.Fatalf("wrong error: %v", err)
+	}
+	//processed messages were deleted from the stream
+	for i := 0; ; i++ {
+		if entries, _ := server.Stream("S:client"); len(entries) == 0 {
+			break
+		} else if i == 100 {
+			t.Fatalf("processed messages not deleted: %+v", entries)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWrongType(t *testing.T) {
+	//the server rejects the commands, so the popper fails
+	//instead of trying to reconnect
+	server := miniredis.RunT(t)
+	server.Set("S:string", "not a queue")
+	for _, mode := range []string{modeList, modeStream} {
+		hostPort := strings.Split(server.Addr(), ":")
+		port, _ := strconv.Atoi(hostPort[1])
+		p := &popper{Server: hostPort[0], Port: port, QName: "S:string", Mode: mode, ReconnectMin: 10, ReconnectMax: 50}
+		if err := p.Validate(); err != nil {
+			t.Fatalf("invalid popper: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := p.Listen(ctx, micro.Root())
+		cancel()
+		if err == nil || !strings.Contains(err.Error(), "WRONGTYPE") {
+			t.Errorf("%s mode: wrong error: %v", mode, err)
+		}
+	}
+}
+
+func TestConnectionLost(t *testing.T) {
+	for _, c := range []struct {
+		err         error
+		lost, retry bool
+	}{
+		{errors.Wrap(io.EOF, "failed sending BRPOP"), true, false},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true, false},
+		{errors.New("WRONGTYPE Operation against a key holding the wrong kind of value"), false, false},
+		{errors.Wrap(errors.New("redis: connection pool timeout"), "failed sending BRPOP"), false, true},
+	} {
+		if connectionLost(c.err) != c.lost || poolTimeout(c.err) != c.retry {
+			t.Errorf("%v: wrong classification", c.err)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	server := miniredis.RunT(t)
+	_, stop := runPopper(t, server, &popper{QName: "Q:client"})
+	defer stop()
+	c, err := NewClient(server.Addr(), "Q:client")
+	if err != nil {
+		t.Fatalf("no client: %v", err)
+	}
+	defer c.Close()
+
+	var text string
+	if err := c.Call(context.Background(), "/test/echo", echo{Text: "hello"}, &text); err != nil || text != "hello" {
+		t.Fatalf("call failed: %v %s", err, text)
+	}
+	err = c.Call(context.Background(), "/test/echo", echo{}, &text)
+	if e, ok := err.(*micro.Error); !ok || e.Code != micro.CodeInvalid {
+		t.Fatalf("wrong error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = c.Call(ctx, "/test/slow", echo{Text: "zzz"}, &text)
+	if e, ok := err.(*micro.Error); !ok || e.Code != micro.CodeTimeout {
+		t.Fatalf("wrong error: %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/jansemmelink/msf/lib/log"
+)
+
+//In reliable mode, a popped message is moved to the processing list of the
+//consumer and only removed after it was processed, so that messages are not
+//lost when a consumer crashes. Each consumer refreshes a heartbeat key that
+//expires after the visibility timeout, and messages in the processing lists
+//of consumers without a heartbeat are recovered to the queue by consumers
+//that are still running:
+//	<qname>:consumers           set of consumer names
+//	<qname>:consumer:<name>     heartbeat of the consumer
+//	<qname>:processing:<name>   messages being processed by the consumer
+
+func (p popper) consumersKey() string {
+	return p.QName + ":consumers"
+}
+
+func (p popper) heartbeatKey(consumer string) string {
+	return p.QName + ":consumer:" + consumer
+}
+
+func (p popper) processingList(consumer string) string {
+	return p.QName + ":processing:" + consumer
+}
+
+//next pops the next message with its ID in a stream,
+//or returns "" when the queue is idle
+func (p popper) next(pool Redis) (string, string, error) {
+	switch {
+	case p.Mode == modeStream:
+		return p.nextEntry(pool)
+	case p.Reliable:
+		data, err := pool.BRPOPLPUSH(p.QName, p.processingList(p.Consumer), 1)
+		return "", data, err
+	}
+	data, err := pool.BRPOP(p.QName, 1)
+	return "", data, err
+}
+
+//ack a processed message, removing it from the processing list
+//or acknowledging it in the consumer group, and optionally deleting it from the stream
+func (p popper) ack(pool Redis, id string, data string) {
+	var err error
+	switch {
+	case p.Mode == modeStream:
+		if err = pool.XACK(p.QName, p.Group, id); err == nil && p.DeleteProcessed {
+			if err := pool.XDEL(p.QName, id); err != nil {
+				log.Errorf("%s: Failed to delete processed message %s: %v", p.QName, id, err)
+			}
+		}
+	case p.Reliable:
+		_, err = pool.LREM(p.processingList(p.Consumer), 1, data)
+	}
+	if err != nil {
+		log.Errorf("%s: Failed to ack, message will be processed again: %v", p.QName, err)
+	}
+}
+
+//heartbeat registers the consumer and refreshes its heartbeat until stop is done,
+//recovering messages left by other consumers that stopped
+//Messages left by a previous run with the same consumer name are recovered
+//before popping starts, because once popping, the processing list of this
+//consumer holds messages that are being processed.
+func (p popper) heartbeat(stop context.Context, pool Redis) {
+	visibility := time.Duration(p.Visibility) * time.Millisecond
+	ticker := time.NewTicker(visibility / 3)
+	defer ticker.Stop()
+	for {
+		if err := pool.SADD(p.consumersKey(), p.Consumer); err != nil {
+			log.Errorf("%s: Failed to register consumer %s: %v", p.QName, p.Consumer, err)
+		} else if err := pool.SETEX(p.heartbeatKey(p.Consumer), time.Now().Format(time.RFC3339), int((visibility+time.Second-1)/time.Second)); err != nil {
+			log.Errorf("%s: Failed to refresh heartbeat of %s: %v", p.QName, p.Consumer, err)
+		}
+		p.recoverStale(pool)
+		select {
+		case <-ticker.C:
+		case <-stop.Done():
+			return
+		}
+	}
+}
+
+//recoverStale recovers messages of consumers without a heartbeat
+func (p popper) recoverStale(pool Redis) {
+	consumers, err := pool.SMEMBERS(p.consumersKey())
+	if err != nil {
+		log.Errorf("%s: Failed to get consumers: %v", p.QName, err)
+		return
+	}
+	for _, consumer := range consumers {
+		if consumer == p.Consumer {
+			continue
+		}
+		if alive, err := pool.EXISTS(p.heartbeatKey(consumer)); err != nil || alive {
+			continue
+		}
+		if p.recover(pool, consumer) {
+			pool.SREM(p.consumersKey(), consumer)
+		}
+	}
+}
+
+//recover moves the messages in the processing list of a consumer back to the queue,
+//returns false if it failed
+func (p popper) recover(pool Redis, consumer string) bool {
+	count := 0
+	for {
+		data, err := pool.RPOPLPUSH(p.processingList(consumer), p.QName)
+		if err != nil {
+			log.Errorf("%s: Failed to recover messages of %s: %v", p.QName, consumer, err)
+			return false
+		}
+		if data == "" {
+			break
+		}
+		count++
+	}
+	if count > 0 {
+		log.Infof("%s: Recovered %d messages of consumer %s", p.QName, count, consumer)
+	}
+	return true
+}
+
+//unregister a consumer that stopped after processing all its messages,
+//else leave them to be recovered after the visibility timeout
+func (p popper) unregister(pool Redis) {
+	if n, err := pool.LLEN(p.processingList(p.Consumer)); err != nil || n > 0 {
+		log.Errorf("%s: Consumer %s stopped with %d unprocessed messages: %v", p.QName, p.Consumer, n, err)
+		return
+	}
+	pool.SREM(p.consumersKey(), p.Consumer)
+	pool.DEL(p.heartbeatKey(p.Consumer))
+}
@@ -0,0 +1,103 @@
+package redis
+
+import (
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jansemmelink/msf/lib/log"
+)
+
+//In stream mode, messages are read from a REDIS stream by a consumer group,
+//so that several consumers share the stream and each message is processed
+//by one of them. Producers add the message to the stream in a field named
+//"message", e.g.:
+//	XADD <qname> * message '{"header":{...},"request":{...}}'
+//Messages are acknowledged after they were processed, and messages that were
+//not acknowledged within the visibility timeout, e.g. because the consumer
+//crashed, are claimed by another consumer in the group.
+//Processed messages stay in the stream for other groups that read it, so
+//producers should trim it, e.g. with XADD <qname> MAXLEN ~ 10000 * ...,
+//or configure deleteProcessed when the stream has only this group.
+
+const (
+	modeList    = "list"
+	modeStream  = "stream"
+	streamField = "message"
+)
+
+//nextEntry reads the next message from the stream,
+//or returns "" when the stream is idle
+func (p popper) nextEntry(pool Redis) (string, string, error) {
+	entry, err := p.claim(pool)
+	if err == nil && entry == nil {
+		entry, err = pool.XREADGROUP(p.QName, p.Group, p.Consumer, 1)
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "NOGROUP") {
+			return "", "", p.createGroup(pool)
+		}
+		return "", "", err
+	}
+	if entry == nil {
+		return "", "", nil
+	}
+	data, ok := entry.Values[streamField].(string)
+	if !ok {
+		//not a valid message, will be discarded
+		jsonValues, _ := json.Marshal(entry.Values)
+		data = string(jsonValues)
+	}
+	return entry.ID, data, nil
+}
+
+//createGroup creates the consumer group to read all messages in the stream
+func (p popper) createGroup(pool Redis) error {
+	if err := pool.XGROUPCREATE(p.QName, p.Group, "0"); err != nil && !strings.Contains(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	log.Infof("%s: Created consumer group %s", p.QName, p.Group)
+	return nil
+}
+
+//claim a message of another consumer that was not acknowledged within the
+//visibility timeout, checking at most every third of the timeout while
+//there is nothing to claim
+func (p popper) claim(pool Redis) (*StreamEntry, error) {
+	visibility := time.Duration(p.Visibility) * time.Millisecond
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(p.lastClaim)
+	if now-last < int64(visibility/3) || !atomic.CompareAndSwapInt64(p.lastClaim, last, now) {
+		return nil, nil
+	}
+	pending, err := pool.XPENDING(p.QName, p.Group, "", 10)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range pending {
+		if entry.Idle < visibility {
+			continue
+		}
+		claimed, err := pool.XCLAIM(p.QName, p.Group, p.Consumer, visibility, entry.ID)
+		if err != nil {
+			return nil, err
+		}
+		if len(claimed) > 0 {
+			log.Infof("%s: Claimed message %s of consumer %s", p.QName, entry.ID, entry.Consumer)
+			atomic.StoreInt64(p.lastClaim, 0) //check again for more
+			return &claimed[0], nil
+		}
+	}
+	return nil, nil
+}
+
+//leaveGroup removes a consumer that stopped after processing all its messages,
+//else leave them to be claimed after the visibility timeout
+func (p popper) leaveGroup(pool Redis) {
+	if pending, err := pool.XPENDING(p.QName, p.Group, p.Consumer, 1); err != nil || len(pending) > 0 {
+		log.Errorf("%s: Consumer %s stopped with unprocessed messages: %v", p.QName, p.Consumer, err)
+		return
+	}
+	pool.XGROUPDELCONSUMER(p.QName, p.Group, p.Consumer)
+}
@@ -17,16 +17,49 @@ type Redis interface {
 	BLPOP(queuename string, waitTime int) (string, error)
 	LPUSH(queuename string, value string) error
 	BRPOP(queuename string, waitTime int) (string, error)
+	BRPOPLPUSH(source string, destination string, waitTime int) (string, error)
+	RPOPLPUSH(source string, destination string) (string, error)
 	SET(key string, value string) error
 	SETEX(key string, value string, ttl int) error
 	SETNX(key string, value string, ttl int) error
 	GET(key string) (string, error)
+	EXISTS(key string) (bool, error)
 	DEL(key ...string) error
 	LLEN(queueName string) (int, error)
+	LRANGE(queueName string, start, stop int) ([]string, error)
+	LREM(queueName string, count int, value string) (int, error)
+	SADD(key string, member string) error
+	SREM(key string, member string) error
+	SMEMBERS(key string) ([]string, error)
 	SCAN(key ...interface{}) (int, []string, error)
+	TYPE(key string) (string, error)
+	XADD(stream string, values map[string]interface{}) (string, error)
+	XGROUPCREATE(stream string, group string, start string) error
+	XGROUPDELCONSUMER(stream string, group string, consumer string) error
+	XREADGROUP(stream string, group string, consumer string, waitTime int) (*StreamEntry, error)
+	XACK(stream string, group string, ids ...string) error
+	XDEL(stream string, ids ...string) error
+	XPENDING(stream string, group string, consumer string, count int) ([]PendingEntry, error)
+	XCLAIM(stream string, group string, consumer string, minIdle time.Duration, ids ...string) ([]StreamEntry, error)
 	Available() int
 	MaxActive() int
 	Stats() string
+	PING() error
+	Close() error
+}
+
+//StreamEntry is a message in a REDIS stream
+type StreamEntry struct {
+	ID     string
+	Values map[string]interface{}
+}
+
+//PendingEntry is a stream message delivered to a consumer in a group
+//that was not yet acknowledged
+type PendingEntry struct {
+	ID       string
+	Consumer string
+	Idle     time.Duration
 }
 
 // NewRedis ..
@@ -0,0 +1,177 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/jansemmelink/msf/lib/micro"
+	"github.com/jansemmelink/msf/lib/mq"
+)
+
+//NewClient calls operations on the REST listener at baseURL,
+//e.g. "http://localhost:8000"
+//Requests are POSTed, unless the operation does not allow POST, then the
+//client uses one of the methods it allows and remembers it for the path.
+func NewClient(baseURL string) mq.IClient {
+	return client{baseURL: strings.TrimSuffix(baseURL, "/"), http: &http.Client{}, methods: &sync.Map{}}
+}
+
+type client struct {
+	baseURL string
+	http    *http.Client
+	methods *sync.Map //path -> method when not POST
+}
+
+//preferredMethods to call an operation with, in order
+var preferredMethods = []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodGet, http.MethodDelete}
+
+func (c client) Call(ctx context.Context, path string, req interface{}, res interface{}) error {
+	ctx, cancel := mq.CallContext(ctx)
+	defer cancel()
+	body, err := json.Marshal(req)
+	if err != nil {
+		return micro.Errorf(micro.CodeInvalid, "cannot encode request %T: %v", req, err)
+	}
+	method := http.MethodPost
+	if m, ok := c.methods.Load(path); ok {
+		method = m.(string)
+	}
+	httpRes, data, err := c.do(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	if httpRes.StatusCode == http.StatusMethodNotAllowed {
+		//retry once with a method allowed by the operation
+		if allowed := allowedMethod(httpRes.Header.Get("Allow")); allowed != "" && allowed != method {
+			c.methods.Store(path, allowed)
+			if httpRes, data, err = c.do(ctx, allowed, path, body); err != nil {
+				return err
+			}
+		}
+	}
+
+	if httpRes.StatusCode >= 300 {
+		e := &micro.Error{}
+		if err := json.Unmarshal(data, e); err != nil || e.Code == "" {
+			return micro.Errorf(errorCode(httpRes.StatusCode), "HTTP %s", httpRes.Status)
+		}
+		return e
+	}
+	return mq.DecodeResponse(data, res)
+}
+
+//do sends the JSON request body with the method, or as URL params
+//for GET and DELETE, and returns the response with its body
+func (c client) do(ctx context.Context, method string, path string, body []byte) (*http.Response, []byte, error) {
+	u := c.baseURL + "/" + strings.TrimPrefix(path, "/")
+	var reader io.Reader
+	if method == http.MethodGet || method == http.MethodDelete {
+		params, err := queryValues(body)
+		if err != nil {
+			return nil, nil, micro.Errorf(micro.CodeInvalid, "cannot send request with %s: %v", method, err)
+		}
+		if len(params) > 0 {
+			u += "?" + params.Encode()
+		}
+	} else {
+		reader = bytes.NewReader(body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, nil, micro.Errorf(micro.CodeInvalid, "invalid path %s: %v", path, err)
+	}
+	if reader != nil {
+		httpReq.Header.Set("Content-Type", contentTypeJSON)
+	}
+	httpReq.Header.Set("Accept", contentTypeJSON)
+	httpRes, err := c.http.Do(httpReq)
+	if err != nil {
+		return nil, nil, mq.CallError(ctx, err)
+	}
+	defer httpRes.Body.Close()
+	data, err := ioutil.ReadAll(httpRes.Body)
+	if err != nil {
+		return nil, nil, mq.CallError(ctx, err)
+	}
+	return httpRes, data, nil
+}
+
+//allowedMethod picks the preferred method in an Allow header
+func allowedMethod(allow string) string {
+	for _, method := range preferredMethods {
+		for _, allowed := range strings.Split(allow, ",") {
+			if strings.TrimSpace(allowed) == method {
+				return method
+			}
+		}
+	}
+	return ""
+}
+
+//queryValues encodes a JSON request as URL params the way bindParams
+//decodes them, with dotted names for nested fields and repeated params
+//for the values of a slice
+func queryValues(body []byte) (url.Values, error) {
+	var request interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&request); err != nil {
+		return nil, err
+	}
+	params := url.Values{}
+	switch request.(type) {
+	case nil:
+		return params, nil
+	case map[string]interface{}:
+		return params, addValues(params, "", request)
+	}
+	return nil, fmt.Errorf("request is not an object")
+}
+
+func addValues(params url.Values, name string, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+	case map[string]interface{}:
+		for field, value := range v {
+			if err := addValues(params, name+field+".", value); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			switch value.(type) {
+			case map[string]interface{}, []interface{}:
+				return fmt.Errorf("%s cannot be a URL param", strings.TrimSuffix(name, "."))
+			}
+			if err := addValues(params, name, value); err != nil {
+				return err
+			}
+		}
+	default:
+		params.Add(strings.TrimSuffix(name, "."), fmt.Sprint(v))
+	}
+	return nil
+}
+
+func (c client) Close() error {
+	c.http.CloseIdleConnections()
+	return nil
+}
+
+//errorCode maps an HTTP status to an error code
+func errorCode(status int) micro.Code {
+	for code, s := range statusCodes {
+		if s == status {
+			return code
+		}
+	}
+	return micro.CodeInternal
+}
@@ -0,0 +1,224 @@
+package rest
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jansemmelink/msf/lib/micro"
+	"github.com/jansemmelink/msf/lib/schema"
+)
+
+func init() {
+	micro.Domain("rest").AddName("openapi", &openAPIOper{})
+}
+
+//Document is an OpenAPI 3 specification of the REST API
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+//Info about the API
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+//PathItem has the operation for each HTTP method (lower case) of a path
+type PathItem map[string]*Operation
+
+//Operation describes how to call an operation with one HTTP method
+type Operation struct {
+	OperationID string              `json:"operationId"`
+	Description string              `json:"description,omitempty"`
+	Tags        []string            `json:"tags,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+//Parameter of an operation in the URL query
+type Parameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *schema.Schema `json:"schema"`
+}
+
+//RequestBody of an operation
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+//Response of an operation, or a reference to a response in Components
+type Response struct {
+	Ref         string               `json:"$ref,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+//MediaType has the schema of a request or response body
+type MediaType struct {
+	Schema *schema.Schema `json:"schema"`
+}
+
+//Components are shared by the operations
+type Components struct {
+	Schemas   map[string]*schema.Schema `json:"schemas"`
+	Responses map[string]Response       `json:"responses"`
+}
+
+//OpenAPI documents the operations in domain d and its sub domains
+//as they are served by Router(d)
+func OpenAPI(d micro.IDomain, info Info) Document {
+	doc := Document{
+		OpenAPI: "3.1.0",
+		Info:    info,
+		Paths:   make(map[string]PathItem),
+		Components: Components{
+			Schemas: map[string]*schema.Schema{
+				"Error": {
+					Type: "object",
+					Properties: map[string]*schema.Schema{
+						"code":    {Type: "string", Enum: errorCodes()},
+						"message": {Type: "string"},
+						"details": {Description: "Problems with request fields when the code is invalid"},
+					},
+					Required: []string{"code", "message"},
+				},
+			},
+			Responses: make(map[string]Response),
+		},
+	}
+	errorResponses := map[string]Response{}
+	for code, status := range statusCodes {
+		doc.Components.Responses[string(code)] = Response{
+			Description: http.StatusText(status),
+			Content:     map[string]MediaType{contentTypeJSON: {Schema: &schema.Schema{Ref: "#/components/schemas/Error"}}},
+		}
+		errorResponses[strconv.Itoa(status)] = Response{Ref: "#/components/responses/" + string(code)}
+	}
+
+	micro.Walk(d, func(path string, d micro.IDomain) {
+		for name, oper := range d.Opers() {
+			operPath := strings.TrimSuffix(path, "/") + "/" + name
+			types := d.Types(name)
+			request := schema.Of(types.Request)
+
+			methods := defaultMethods
+			if m, ok := oper.(IMethods); ok {
+				methods = m.Methods()
+			}
+			item := PathItem{}
+			for _, method := range methods {
+				o := &Operation{
+					OperationID: strings.ToLower(method) + micro.ExportedName(operPath),
+					Tags:        []string{path},
+					Responses:   map[string]Response{},
+				}
+				if doc, ok := oper.(micro.IDoc); ok {
+					o.Description = doc.Doc()
+				}
+				for status, res := range errorResponses {
+					o.Responses[status] = res
+				}
+				if types.Response == nil {
+					o.Responses["204"] = Response{Description: "Done"}
+				} else {
+					o.Responses["200"] = Response{
+						Description: "Response",
+						Content:     map[string]MediaType{contentTypeJSON: {Schema: schema.Of(types.Response)}},
+					}
+				}
+				if method == http.MethodGet || method == http.MethodDelete {
+					o.Parameters = queryParameters(request)
+				} else {
+					o.RequestBody = &RequestBody{
+						Content: map[string]MediaType{
+							contentTypeJSON: {Schema: request},
+							contentTypeForm: {Schema: request},
+						},
+					}
+				}
+				item[strings.ToLower(method)] = o
+			}
+			doc.Paths[operPath] = item
+		}
+	})
+	return doc
+}
+
+//queryParameters describe the request fields that may be set in the URL,
+//with dotted names for fields of nested structs (see bindParams)
+func queryParameters(request *schema.Schema) []Parameter {
+	params := make([]Parameter, 0)
+	addParameters(&params, "", request)
+	return params
+}
+
+func addParameters(params *[]Parameter, prefix string, s *schema.Schema) {
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	required := map[string]bool{}
+	for _, name := range s.Required {
+		required[name] = true
+	}
+	for _, name := range names {
+		p := s.Properties[name]
+		if p.Type == "object" && p.Properties != nil {
+			addParameters(params, prefix+name+".", p)
+			continue
+		}
+		*params = append(*params, Parameter{Name: prefix + name, In: "query", Description: p.Description, Required: required[name], Schema: p})
+	}
+}
+
+func errorCodes() []interface{} {
+	codes := make([]string, 0, len(statusCodes))
+	for code := range statusCodes {
+		codes = append(codes, string(code))
+	}
+	sort.Strings(codes)
+	values := make([]interface{}, len(codes))
+	for i, code := range codes {
+		values[i] = code
+	}
+	return values
+}
+
+//openAPIOper returns the OpenAPI document of all operations
+type openAPIOper struct {
+	micro.Service
+	Title   string `json:"title" doc:"Title of the API (defaults to the program name)."`
+	Version string `json:"version" doc:"Version of the API (defaults to the version of the program)."`
+}
+
+func (o *openAPIOper) Validate() error {
+	build := micro.Build()
+	if o.Title == "" {
+		o.Title = build.Program
+	}
+	if o.Version == "" {
+		o.Version = build.Version
+	}
+	return nil
+}
+
+func (o openAPIOper) Doc() string {
+	return "Get the OpenAPI specification of the REST API of all operations."
+}
+
+func (o openAPIOper) Handle() (interface{}, interface{}) {
+	return OpenAPI(micro.Root(), Info{Title: o.Title, Version: o.Version}), nil
+}
+
+func (o openAPIOper) Describe() (interface{}, interface{}) { return Document{}, nil }
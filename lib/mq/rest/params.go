@@ -0,0 +1,182 @@
+package rest
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jansemmelink/msf/lib/log"
+	"github.com/jansemmelink/msf/lib/micro"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+//bindParams sets URL params in the operation request struct
+//Params are matched to public fields by Go or JSON name, using dotted
+//names for nested struct fields, e.g. "?address.city=x", and repeated
+//params to set slices, e.g. "?id=1&id=2".
+func bindParams(oper micro.IMicro, params url.Values) error {
+	operValue := reflect.ValueOf(oper).Elem()
+	for paramName, paramValues := range params {
+		if err := bindParam(operValue, paramName, strings.Split(paramName, "."), paramValues); err != nil {
+			return err
+		}
+	}
+	log.Debugf("Request Params: %+v", oper)
+	return nil
+}
+
+//bindParam sets the field at path in struct v
+func bindParam(v reflect.Value, paramName string, path []string, paramValues []string) error {
+	fieldValue, ok := findField(v, path[0])
+	if !ok {
+		return fmt.Errorf("unknown URL param %s", paramName)
+	}
+	if !fieldValue.CanSet() {
+		return fmt.Errorf("URL param not allowed: %s", paramName)
+	}
+	if len(path) > 1 {
+		for fieldValue.Kind() == reflect.Ptr {
+			if fieldValue.IsNil() {
+				fieldValue.Set(reflect.New(fieldValue.Type().Elem()))
+			}
+			fieldValue = fieldValue.Elem()
+		}
+		if fieldValue.Kind() != reflect.Struct || fieldValue.Type() == timeType {
+			return fmt.Errorf("unknown URL param %s", paramName)
+		}
+		return bindParam(fieldValue, paramName, path[1:], paramValues)
+	}
+	if err := setValues(fieldValue, paramValues); err != nil {
+		return fmt.Errorf("invalid URL param %s: %v", paramName, err)
+	}
+	return nil
+}
+
+//findField finds a struct field by Go name or JSON name,
+//including fields promoted from embedded structs
+func findField(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for fti := 0; fti < t.NumField(); fti++ {
+		ft := t.Field(fti)
+		if ft.Anonymous && ft.Type.Kind() == reflect.Struct {
+			if fieldValue, ok := findField(v.Field(fti), name); ok {
+				return fieldValue, true
+			}
+			continue
+		}
+		jsonName := strings.Split(ft.Tag.Get("json"), ",")[0]
+		if jsonName == "-" {
+			continue
+		}
+		if name == ft.Name || name == jsonName {
+			return v.Field(fti), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+//setValues sets a slice from all values, or any other field from a single value
+func setValues(v reflect.Value, values []string) error {
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
+		slice := reflect.MakeSlice(v.Type(), len(values), len(values))
+		for i, s := range values {
+			if err := setValue(slice.Index(i), s); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	}
+	if len(values) != 1 {
+		return fmt.Errorf("expecting one value, got %d", len(values))
+	}
+	return setValue(v, values[0])
+}
+
+//setValue converts the text value to the type of v
+func setValue(v reflect.Value, s string) error {
+	if v.Kind() == reflect.Ptr {
+		p := reflect.New(v.Type().Elem())
+		if err := setValue(p.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	}
+
+	switch v.Type() {
+	case timeType:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+			if t, err := time.Parse(layout, s); err == nil {
+				v.Set(reflect.ValueOf(t))
+				return nil
+			}
+		}
+		return fmt.Errorf("\"%s\" is not a time, expecting RFC3339 or YYYY-MM-DD", s)
+	case durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	//custom types decode themselves
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+	if u, ok := v.Addr().Interface().(json.Unmarshaler); ok {
+		jsonValue, _ := json.Marshal(s)
+		return u.UnmarshalJSON(jsonValue)
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Slice:
+		//[]byte
+		v.SetBytes([]byte(s))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Interface:
+		if v.NumMethod() > 0 {
+			return fmt.Errorf("cannot set %v from URL", v.Type())
+		}
+		v.Set(reflect.ValueOf(s))
+	default:
+		return fmt.Errorf("cannot set %v from URL", v.Type())
+	}
+	return nil
+}
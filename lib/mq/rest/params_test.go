@@ -0,0 +1,52 @@
+package rest
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jansemmelink/msf/lib/log/level"
+	"github.com/jansemmelink/msf/lib/micro"
+)
+
+type paramTest struct {
+	micro.Service
+	private string
+	Name    string        `json:"name,omitempty"`
+	Count   int           `json:"count"`
+	Ratio   float64       `json:"ratio"`
+	Enabled bool          `json:"enabled"`
+	Timeout time.Duration `json:"timeout"`
+	Since   time.Time     `json:"since"`
+	IDs     []int         `json:"id"`
+	Limit   *int          `json:"limit"`
+	Level   level.Enum    `json:"level"`
+	Address struct {
+		City string `json:"city"`
+	} `json:"address"`
+	Ignored string `json:"-"`
+}
+
+func (p *paramTest) Validate() error { return nil }
+
+func (p paramTest) Handle() (interface{}, interface{}) { return nil, nil }
+
+func TestBindParams(t *testing.T) {
+	params, _ := url.ParseQuery("name=Jan&count=3&ratio=0.5&enabled=true&timeout=1m&since=2019-02-03&id=1&id=2&limit=10&level=debug&address.city=Paris")
+	p := &paramTest{}
+	if err := bindParams(p, params); err != nil {
+		t.Fatalf("bind failed: %v", err)
+	}
+	if p.Name != "Jan" || p.Count != 3 || p.Ratio != 0.5 || !p.Enabled || p.Timeout != time.Minute ||
+		p.Since != time.Date(2019, 2, 3, 0, 0, 0, 0, time.UTC) || len(p.IDs) != 2 || p.IDs[1] != 2 ||
+		p.Limit == nil || *p.Limit != 10 || p.Level != level.Debug || p.Address.City != "Paris" {
+		t.Fatalf("wrong values: %+v", p)
+	}
+
+	for _, query := range []string{"count=x", "unknown=1", "private=1", "Ignored=1", "name=a&name=b", "address.unknown=1", "name.x=1"} {
+		params, _ := url.ParseQuery(query)
+		if err := bindParams(&paramTest{}, params); err == nil {
+			t.Errorf("?%s did not fail", query)
+		}
+	}
+}
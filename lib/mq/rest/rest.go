@@ -1,11 +1,14 @@
 package rest
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
+	"io/ioutil"
+	"mime"
 	"net/http"
-	"reflect"
+	"net/url"
+	"sort"
 	"strings"
 
 	"github.com/jansemmelink/msf/lib/log"
@@ -36,111 +39,242 @@ func (p *rest) Validate() error {
 }
 
 //Listen ...
-func (p rest) Listen(d micro.IDomain) {
+func (p rest) Listen(ctx context.Context, d micro.IDomain) error {
 	addr := fmt.Sprintf("%s:%d", p.Addr, p.Port)
-	log.Debugf("REDIS Listening to %s ...", addr)
-	if err := http.ListenAndServe(addr, router{d: d}); err != nil {
-		panic(errors.Wrapf(err, "Failed to serve HTTP REST"))
+	log.Debugf("REST Listening to %s ...", addr)
+	server := &http.Server{Addr: addr, Handler: Router(d)}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		return errors.Wrapf(err, "Failed to serve HTTP REST")
+	case <-ctx.Done():
+	}
+
+	//stop accepting connections and wait for requests in progress
+	log.Infof("REST stopping, waiting up to %v for requests in progress...", p.DrainTimeout())
+	drainCtx, cancel := context.WithTimeout(context.Background(), p.DrainTimeout())
+	defer cancel()
+	if err := server.Shutdown(drainCtx); err != nil {
+		server.Close()
+		return errors.Wrapf(err, "Failed to stop HTTP REST")
 	}
+	return nil
+}
+
+//Router returns the HTTP handler that serves operations in domain d
+func Router(d micro.IDomain) http.Handler {
+	return router{d: d}
 }
 
 type router struct {
 	d micro.IDomain
 }
 
+//IMethods may be implemented by an operation to specify the HTTP methods
+//that may be used to invoke it. When not implemented, GET and POST are allowed.
+type IMethods interface {
+	Methods() []string
+}
+
+var defaultMethods = []string{http.MethodGet, http.MethodPost}
+
+const (
+	contentTypeJSON = "application/json"
+	contentTypeForm = "application/x-www-form-urlencoded"
+)
+
 func (r router) ServeHTTP(res http.ResponseWriter, req *http.Request) {
-	//get "/<domain>/<oper>" from the URL
-	path := strings.Split(req.URL.Path, "/")
-	if len(path) < 3 {
-		http.Error(res, "invalid domain", http.StatusBadRequest)
-		return
+	//get "/<domain>/.../<oper>" from the URL
+	path := make([]string, 0)
+	for _, name := range strings.Split(req.URL.Path, "/") {
+		if name != "" {
+			path = append(path, name)
+		}
 	}
-	domainName := path[1]
-	operName := path[2]
-	log.Debugf("domain=%s oper=%s", domainName, operName)
 
-	domain := r.d.GetSub(domainName)
-	if domain == nil {
-		subNames := ""
-		for name := range r.d.GetSubs() {
-			subNames += "|" + name
-		}
-		if len(subNames) > 0 {
-			subNames = subNames[1:]
-		}
-		http.Error(res, "unknown domain, expecting "+subNames, http.StatusBadRequest)
+	//all responses are JSON
+	if !acceptsJSON(req.Header.Get("Accept")) {
+		writeJSON(res, http.StatusNotAcceptable, micro.Errorf(micro.CodeInvalid, "responses are only available as %s", contentTypeJSON))
 		return
 	}
 
-	oper := domain.Get(operName)
-	if oper == nil {
-		operNames := ""
-		log.Debugf("names...")
-		for name := range domain.Opers() {
-			log.Debugf("name=%s", name)
-			operNames += "|" + name
-		}
-		if len(operNames) > 0 {
-			operNames = operNames[1:]
+	//when the path ends at a domain, list its contents
+	if domain, err := micro.Resolve(r.d, strings.Join(path, "/")); err == nil {
+		if !allowMethod(res, req, []string{http.MethodGet}) {
+			return
 		}
-		http.Error(res, "unknown oper, expecting "+operNames, http.StatusBadRequest)
+		writeListing(res, "/"+strings.Join(path, "/"), domain)
 		return
 	}
+	domainName := "/" + strings.Join(path[:len(path)-1], "/")
+	operName := path[len(path)-1]
+	log.Debugf("%s domain=%s oper=%s", req.Method, domainName, operName)
 
-	//allocate a new operation request structure
-	operStructType := reflect.TypeOf(oper)
-	if operStructType.Kind() == reflect.Ptr {
-		operStructType = operStructType.Elem()
+	//check the method if the operation exists,
+	//else Invoke() reports what is not found
+	if domain, err := micro.Resolve(r.d, domainName); err == nil {
+		if oper := domain.Get(operName); oper != nil {
+			methods := defaultMethods
+			if m, ok := oper.(IMethods); ok {
+				methods = m.Methods()
+			}
+			if !allowMethod(res, req, methods) {
+				return
+			}
+		}
 	}
-	log.Debugf("t=%v", operStructType)
 
-	//allocate a new copy of the operation (request) struct
-	operRequest := reflect.New(operStructType).Interface()
-	//copy operation values from registered oper
-	operRequest = oper
-	log.Debugf("Allocated %T=%+v", operRequest, operRequest)
-
-	//parse operation request from body
+	//read operation request from body as JSON,
+	//or form values that are bound like URL params
+	var body []byte
+	var form url.Values
 	if req.Body != nil {
-		if err := json.NewDecoder(req.Body).Decode(&operRequest); err != nil && err != io.EOF {
-			http.Error(res, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			writeError(res, micro.Errorf(micro.CodeInvalid, "invalid request body: %v", err))
 			return
 		}
 	}
-	log.Debugf("Request Body: %+v", operRequest)
-
-	//todo: parse URL params
-	operValue := reflect.ValueOf(operRequest).Elem()
-	log.Debugf("v=%v", operValue)
-	for paramName, paramValues := range req.URL.Query() {
-		found := false
-		for fti := 0; fti < operStructType.NumField(); fti++ {
-			ft := operStructType.Field(fti)
-			log.Debugf("Check p=%s against f[%d]=%s", paramName, fti, ft.Name)
-			if paramName == ft.Name || paramName == ft.Tag.Get("json") {
-				log.Debugf("Got match on ft[%d]=%s", fti, ft.Name)
-				fieldValue := operValue.Field(fti)
-				if !fieldValue.CanSet() {
-					http.Error(res, "URL param not allowed: "+paramName, http.StatusBadRequest)
-					return
-				}
-				fieldValue.Set(reflect.ValueOf(paramValues[0]))
-				found = true
-				break
+	if len(body) > 0 {
+		contentType := contentTypeJSON
+		if header := req.Header.Get("Content-Type"); header != "" {
+			var err error
+			if contentType, _, err = mime.ParseMediaType(header); err != nil {
+				writeJSON(res, http.StatusUnsupportedMediaType, micro.Errorf(micro.CodeInvalid, "invalid Content-Type: %v", err))
+				return
 			}
 		}
-		if !found {
-			http.Error(res, "unknown URL param "+paramName, http.StatusBadRequest)
+		switch {
+		case contentType == contentTypeJSON || strings.HasSuffix(contentType, "+json"):
+		case contentType == contentTypeForm:
+			var err error
+			if form, err = url.ParseQuery(string(body)); err != nil {
+				writeError(res, micro.Errorf(micro.CodeInvalid, "invalid form: %v", err))
+				return
+			}
+			body = nil
+		default:
+			writeJSON(res, http.StatusUnsupportedMediaType, micro.Errorf(micro.CodeInvalid, "Content-Type %s not supported, expecting %s|%s", contentType, contentTypeJSON, contentTypeForm))
 			return
 		}
 	}
-	log.Debugf("Request Params: %+v", operRequest)
 
-	//execute
-	operResponse, operAudit := operRequest.(micro.IMicro).Handle()
-	log.Debugf("Res: %+v", operResponse)
-	log.Debugf("Audit: %+v", operAudit)
+	result := micro.Invoke(r.d, micro.Request{
+		Path:    domainName,
+		Oper:    operName,
+		Data:    body,
+		Context: req.Context(),
+		Bind: func(oper micro.IMicro) error {
+			if err := bindParams(oper, req.URL.Query()); err != nil {
+				return err
+			}
+			return bindParams(oper, form)
+		},
+	})
+	if result.Err != nil {
+		writeError(res, result.Err)
+		return
+	}
+	log.Debugf("Res: %+v", result.Response)
+	log.Debugf("Audit: %+v", result.Audit)
+
+	if result.Response == nil {
+		res.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(res, http.StatusOK, result.Response)
+}
+
+//allowMethod checks the request method, answering OPTIONS requests
+//and rejecting other methods that are not allowed
+func allowMethod(res http.ResponseWriter, req *http.Request, methods []string) bool {
+	for _, method := range methods {
+		if req.Method == method || (req.Method == http.MethodHead && method == http.MethodGet) {
+			return true
+		}
+	}
+	res.Header().Set("Allow", strings.Join(append([]string{http.MethodOptions}, methods...), ", "))
+	if req.Method == http.MethodOptions {
+		res.WriteHeader(http.StatusNoContent)
+		return false
+	}
+	writeJSON(res, http.StatusMethodNotAllowed, micro.Errorf(micro.CodeInvalid, "method %s not allowed, expecting %s", req.Method, strings.Join(methods, "|")))
+	return false
+}
+
+//acceptsJSON checks if the Accept header allows a JSON response
+func acceptsJSON(accept string) bool {
+	if accept == "" {
+		return true
+	}
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(mediaRange)
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+		switch mediaType {
+		case "*/*", "application/*", contentTypeJSON:
+			return true
+		}
+	}
+	return false
+}
+
+//writeJSON writes the value as a JSON response
+func writeJSON(res http.ResponseWriter, status int, v interface{}) {
+	jsonValue, err := json.Marshal(v)
+	if err != nil {
+		log.Errorf("Failed to encode response %T: %v", v, err)
+		status = http.StatusInternalServerError
+		jsonValue, _ = json.Marshal(micro.Errorf(micro.CodeInternal, "failed to encode response"))
+	}
+	res.Header().Set("Content-Type", contentTypeJSON+"; charset=utf-8")
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.WriteHeader(status)
+	res.Write(jsonValue)
+}
 
-	jsonRes, _ := json.Marshal(operResponse)
-	res.Write(jsonRes)
+//listing describes a domain when the URL does not name an operation
+type listing struct {
+	Domain  string   `json:"domain"`
+	Domains []string `json:"domains"`
+	Opers   []string `json:"opers"`
+}
+
+func writeListing(res http.ResponseWriter, path string, d micro.IDomain) {
+	l := listing{Domain: path, Domains: make([]string, 0), Opers: make([]string, 0)}
+	for name := range d.GetSubs() {
+		l.Domains = append(l.Domains, name)
+	}
+	for name := range d.Opers() {
+		l.Opers = append(l.Opers, name)
+	}
+	sort.Strings(l.Domains)
+	sort.Strings(l.Opers)
+	writeJSON(res, http.StatusOK, l)
+}
+
+//statusCodes maps error codes to HTTP status codes
+var statusCodes = map[micro.Code]int{
+	micro.CodeInvalid:      http.StatusBadRequest,
+	micro.CodeUnauthorized: http.StatusUnauthorized,
+	micro.CodeForbidden:    http.StatusForbidden,
+	micro.CodeNotFound:     http.StatusNotFound,
+	micro.CodeConflict:     http.StatusConflict,
+	micro.CodeUnavailable:  http.StatusServiceUnavailable,
+	micro.CodeTimeout:      http.StatusGatewayTimeout,
+	micro.CodeInternal:     http.StatusInternalServerError,
+}
+
+//writeError writes the error as JSON with the HTTP status for its code
+func writeError(res http.ResponseWriter, err *micro.Error) {
+	status, ok := statusCodes[err.Code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	writeJSON(res, status, err)
 }
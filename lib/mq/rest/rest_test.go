@@ -0,0 +1,289 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jansemmelink/msf/lib/micro"
+)
+
+type echo struct {
+	micro.Service
+	Text string `json:"text"`
+}
+
+func (e *echo) Validate() error { return nil }
+
+func (e echo) Handle() (interface{}, interface{}) { return e.Text, nil }
+
+func init() {
+	micro.Domain("billing").Sub("invoice").AddName("create", &echo{})
+	micro.Root().AddName("ping", &echo{Text: "pong"})
+}
+
+func get(t *testing.T, url string, expectedStatus int, v interface{}) {
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", url, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != expectedStatus {
+		t.Fatalf("GET %s status %d != %d", url, res.StatusCode, expectedStatus)
+	}
+	if v != nil {
+		if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+			t.Fatalf("GET %s invalid response: %v", url, err)
+		}
+	}
+}
+
+func TestNestedPaths(t *testing.T) {
+	server := httptest.NewServer(Router(micro.Root()))
+	defer server.Close()
+
+	var text string
+	get(t, server.URL+"/billing/invoice/create?text=abc", http.StatusOK, &text)
+	if text != "abc" {
+		t.Fatalf("nested oper returned \"%s\"", text)
+	}
+	get(t, server.URL+"/ping", http.StatusOK, &text)
+	if text != "pong" {
+		t.Fatalf("root oper returned \"%s\"", text)
+	}
+
+	l := listing{}
+	get(t, server.URL+"/billing/invoice", http.StatusOK, &l)
+	if l.Domain != "/billing/invoice" || len(l.Opers) != 2 || l.Opers[0] != "create" {
+		t.Fatalf("wrong listing: %+v", l)
+	}
+	get(t, server.URL+"/", http.StatusOK, &l)
+	if l.Domain != "/" || len(l.Domains) < 2 || len(l.Opers) != 1 || l.Opers[0] != "ping" {
+		t.Fatalf("wrong root listing: %+v", l)
+	}
+
+	get(t, server.URL+"/billing/unknown/create", http.StatusNotFound, nil)
+	get(t, server.URL+"/billing/invoice/unknown", http.StatusNotFound, nil)
+}
+
+type remove struct {
+	echo
+}
+
+func (r remove) Methods() []string { return []string{http.MethodDelete} }
+
+//find only allows GET with the request in URL params
+type find struct {
+	micro.Service
+	IDs     []int   `json:"ids"`
+	Address address `json:"address"`
+}
+
+func (f *find) Validate() error { return nil }
+
+func (f find) Methods() []string { return []string{http.MethodGet} }
+
+func (f find) Handle() (interface{}, interface{}) {
+	return fmt.Sprintf("%v in %s", f.IDs, f.Address.City), nil
+}
+
+func init() {
+	micro.Domain("billing").Sub("invoice").AddName("delete", &remove{})
+	micro.Domain("catalog").AddName("find", &find{})
+}
+
+func do(t *testing.T, method, url, contentType, accept, body string) *http.Response {
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	res.Body.Close()
+	return res
+}
+
+func TestMethodsAndContent(t *testing.T) {
+	server := httptest.NewServer(Router(micro.Root()))
+	defer server.Close()
+	url := server.URL + "/billing/invoice/"
+
+	tests := []struct {
+		method, oper, contentType, accept, body string
+		status                                  int
+	}{
+		{http.MethodPost, "create", "application/json", "", `{"text":"a"}`, http.StatusOK},
+		{http.MethodPost, "create", "", "application/json", `{"text":"a"}`, http.StatusOK},
+		{http.MethodPost, "create", "application/x-www-form-urlencoded", "", `text=a`, http.StatusOK},
+		{http.MethodPost, "create", "text/plain", "", `text`, http.StatusUnsupportedMediaType},
+		{http.MethodGet, "create", "", "text/html", ``, http.StatusNotAcceptable},
+		{http.MethodGet, "create", "", "text/html, */*;q=0.1", ``, http.StatusOK},
+		{http.MethodDelete, "create", "", "", ``, http.StatusMethodNotAllowed},
+		{http.MethodOptions, "create", "", "", ``, http.StatusNoContent},
+		{http.MethodDelete, "delete", "", "", ``, http.StatusOK},
+		{http.MethodGet, "delete", "", "", ``, http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		res := do(t, test.method, url+test.oper, test.contentType, test.accept, test.body)
+		if res.StatusCode != test.status {
+			t.Errorf("%s %s %s: status %d != %d", test.method, test.oper, test.contentType, res.StatusCode, test.status)
+		}
+		if res.StatusCode == http.StatusMethodNotAllowed && res.Header.Get("Allow") == "" {
+			t.Errorf("%s %s: no Allow header", test.method, test.oper)
+		}
+		if res.StatusCode != http.StatusNoContent && !strings.HasPrefix(res.Header.Get("Content-Type"), "application/json") {
+			t.Errorf("%s %s: Content-Type %s", test.method, test.oper, res.Header.Get("Content-Type"))
+		}
+	}
+}
+
+func TestListenStops(t *testing.T) {
+	//use a free port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("no free port: %v", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	p := &rest{Addr: "127.0.0.1", Port: port}
+	p.Validate()
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() {
+		stopped <- p.Listen(ctx, micro.Root())
+	}()
+	time.Sleep(100 * time.Millisecond)
+	res := do(t, http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d/ping", port), "", "", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", res.StatusCode)
+	}
+
+	cancel()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatalf("Listen failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Listen did not stop")
+	}
+}
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(Router(micro.Root()))
+	defer server.Close()
+	c := NewClient(server.URL)
+	defer c.Close()
+
+	var text string
+	if err := c.Call(context.Background(), "/billing/invoice/create", echo{Text: "abc"}, &text); err != nil || text != "abc" {
+		t.Fatalf("call failed: %v %s", err, text)
+	}
+	//operations that do not allow POST are called with a method they allow
+	if err := c.Call(context.Background(), "/billing/invoice/delete", echo{Text: "def"}, &text); err != nil || text != "def" {
+		t.Fatalf("delete failed: %v %s", err, text)
+	}
+	for i := 0; i < 2; i++ {
+		req := find{IDs: []int{1, 2}, Address: address{City: "Cape Town"}}
+		if err := c.Call(context.Background(), "/catalog/find", req, &text); err != nil || text != "[1 2] in Cape Town" {
+			t.Fatalf("find failed: %v %s", err, text)
+		}
+	}
+	if m, _ := c.(client).methods.Load("/catalog/find"); m != http.MethodGet {
+		t.Errorf("method not remembered: %v", m)
+	}
+	err := c.Call(context.Background(), "/billing/unknown", echo{}, &text)
+	if e, ok := err.(*micro.Error); !ok || e.Code != micro.CodeNotFound {
+		t.Fatalf("wrong error: %v", err)
+	}
+	c = NewClient("http://localhost:1")
+	if err := c.Call(context.Background(), "/ping", nil, nil); err == nil || err.(*micro.Error).Code != micro.CodeUnavailable {
+		t.Fatalf("wrong error: %v", err)
+	}
+}
+
+type address struct {
+	City string `json:"city" validate:"required" doc:"Name of the city."`
+}
+
+type order struct {
+	micro.Service
+	Qty     int      `json:"qty" validate:"min=1,max=10" default:"1"`
+	Size    string   `json:"size" validate:"oneof=S|M|L"`
+	Items   []string `json:"items" validate:"required,max=5"`
+	Address address  `json:"address"`
+	Note    *string  `json:"note"`
+}
+
+func (o *order) Validate() error { return nil }
+
+func (o order) Handle() (interface{}, interface{}) { return address{}, nil }
+
+func init() {
+	micro.Domain("shop").AddName("order", &order{})
+}
+
+func TestOpenAPI(t *testing.T) {
+	server := httptest.NewServer(Router(micro.Root()))
+	defer server.Close()
+	doc := Document{}
+	get(t, server.URL+"/rest/openapi?title=test", http.StatusOK, &doc)
+	if doc.OpenAPI != "3.1.0" || doc.Info.Title != "test" || doc.Info.Version == "" {
+		t.Fatalf("wrong document: %+v", doc)
+	}
+
+	post := doc.Paths["/shop/order"]["post"]
+	if post == nil || doc.Paths["/billing/invoice/create"]["get"] == nil || doc.Paths["/billing/invoice/delete"]["delete"] == nil {
+		t.Fatalf("missing paths: %+v", doc.Paths)
+	}
+	if _, ok := doc.Paths["/billing/invoice/delete"]["post"]; ok {
+		t.Fatalf("method not allowed in document")
+	}
+	request := post.RequestBody.Content[contentTypeJSON].Schema
+	qty := request.Properties["qty"]
+	if qty.Type != "integer" || *qty.Minimum != 1 || *qty.Maximum != 10 || qty.Default != 1.0 {
+		t.Fatalf("wrong qty: %+v", qty)
+	}
+	if size := request.Properties["size"]; len(size.Enum) != 3 || size.Enum[2] != "L" {
+		t.Fatalf("wrong size: %+v", size)
+	}
+	if items := request.Properties["items"]; items.Type != "array" || items.Items.Type != "string" || *items.MaxItems != 5 {
+		t.Fatalf("wrong items: %+v", items)
+	}
+	if len(request.Required) != 1 || request.Required[0] != "items" {
+		t.Fatalf("wrong required: %v", request.Required)
+	}
+	if city := request.Properties["address"].Properties["city"]; city.Description != "Name of the city." {
+		t.Fatalf("wrong city: %+v", city)
+	}
+	if !request.Properties["note"].Nullable {
+		t.Fatalf("pointer not nullable")
+	}
+	if res := post.Responses["200"].Content[contentTypeJSON].Schema; res.Properties["city"] == nil {
+		t.Fatalf("wrong response: %+v", res)
+	}
+	if res := post.Responses["400"]; res.Ref != "#/components/responses/invalid" || doc.Components.Responses["invalid"].Description == "" {
+		t.Fatalf("wrong error response: %+v", res)
+	}
+
+	params := doc.Paths["/shop/order"]["get"].Parameters
+	names := []string{}
+	for _, p := range params {
+		names = append(names, p.Name)
+	}
+	if strings.Join(names, ",") != "address.city,items,note,qty,size" || !params[0].Required {
+		t.Fatalf("wrong params: %+v", params)
+	}
+}
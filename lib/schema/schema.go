@@ -0,0 +1,314 @@
+package schema
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Draft is the JSON Schema version of the generated schemas
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+//Schema is a JSON Schema that describes a JSON value
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Nullable             bool               `json:"-"` //encoded as type [Type,"null"]
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Default              interface{}        `json:"default,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	MinProperties        *int               `json:"minProperties,omitempty"`
+	MaxProperties        *int               `json:"maxProperties,omitempty"`
+}
+
+//IEnum may be implemented by custom types like level.Enum
+//to list the values they have in JSON
+type IEnum interface {
+	Values() []string
+}
+
+//For returns the schema of the type of v, or nil when v is nil
+func For(v interface{}) *Schema {
+	if v == nil {
+		return nil
+	}
+	return Of(reflect.TypeOf(v))
+}
+
+//Of returns the schema of values of type t as they are encoded in JSON
+//Public struct fields are properties with their JSON names, including the
+//fields of embedded structs. The doc tag is the description, and the
+//validate, pattern and default tags used by micro validation are rules,
+//e.g. `validate:"required,min=1,max=20"` makes a required property with
+//minLength and maxLength when it is a string.
+func Of(t reflect.Type) *Schema {
+	if t == nil {
+		return nil
+	}
+	return of(t, nil)
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	durationType      = reflect.TypeOf(time.Duration(0))
+	enumType          = reflect.TypeOf((*IEnum)(nil)).Elem()
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+//The custom JSON encodings of types are classified by these functions,
+//which are shared with the client generator so that generated Go types
+//match the schemas.
+
+//IsTime is true for time.Time, encoded as a date-time string
+func IsTime(t reflect.Type) bool { return t == timeType }
+
+//IsDuration is true for time.Duration, encoded as integer nanoseconds
+func IsDuration(t reflect.Type) bool { return t == durationType }
+
+//IsEnum is true for types like level.Enum, encoded as one of the strings
+//listed by IEnum
+func IsEnum(t reflect.Type) bool {
+	return t.Kind() != reflect.Ptr && t.Implements(enumType)
+}
+
+//IsOpaque is true for other types that encode themselves with
+//json.Marshaler, as any JSON value
+func IsOpaque(t reflect.Type) bool {
+	return t.Kind() != reflect.Ptr && !IsTime(t) && !IsEnum(t) && t.Implements(jsonMarshalerType)
+}
+
+//IsText is true for other types encoded as a string with encoding.TextMarshaler
+func IsText(t reflect.Type) bool {
+	return t.Kind() != reflect.Ptr && !IsTime(t) && !IsEnum(t) && !IsOpaque(t) && t.Implements(textMarshalerType)
+}
+
+//of describes type t, with seen the struct types being described
+//to stop at recursive types
+func of(t reflect.Type, seen map[reflect.Type]bool) *Schema {
+	switch {
+	case IsTime(t):
+		return &Schema{Type: "string", Format: "date-time"}
+	case IsDuration(t):
+		return &Schema{Type: "integer", Format: "int64", Description: "Nanoseconds"}
+	case t.Kind() == reflect.Ptr:
+		s := of(t.Elem(), seen)
+		s.Nullable = s.Type != ""
+		return s
+	case IsEnum(t):
+		s := &Schema{Type: "string"}
+		for _, value := range reflect.Zero(t).Interface().(IEnum).Values() {
+			s.Enum = append(s.Enum, value)
+		}
+		return s
+	case IsOpaque(t):
+		//any JSON value
+		return &Schema{}
+	case IsText(t):
+		return &Schema{Type: "string"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: of(t.Elem(), seen)}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: of(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			return &Schema{Type: "object", Description: "Recursive " + t.Name()}
+		}
+		nested := make(map[reflect.Type]bool, len(seen)+1)
+		for st := range seen {
+			nested[st] = true
+		}
+		nested[t] = true
+		s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		s.addFields(t, nested)
+		return s
+	}
+	//interface{} can be any value
+	return &Schema{}
+}
+
+//addFields adds the public fields of struct type t, with those of
+//embedded structs, as properties
+func (s *Schema) addFields(t reflect.Type, seen map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		ft := t.Field(i)
+		if ft.Anonymous && ft.Type.Kind() == reflect.Struct {
+			s.addFields(ft.Type, seen)
+			continue
+		}
+		if ft.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(ft.Tag.Get("json"), ",")[0]
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = ft.Name
+		}
+		fs := of(ft.Type, seen)
+		if doc := ft.Tag.Get("doc"); doc != "" {
+			fs.Description = doc
+		}
+		if fs.applyRules(ft) {
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = fs
+	}
+}
+
+//applyRules adds the validate, pattern and default tags of a field
+//and returns true if the field is required
+func (s *Schema) applyRules(ft reflect.StructField) (required bool) {
+	if pattern := ft.Tag.Get("pattern"); pattern != "" {
+		s.Pattern = pattern
+	}
+	if def, ok := ft.Tag.Lookup("default"); ok {
+		s.Default = s.value(def)
+	}
+	for _, rule := range strings.Split(ft.Tag.Get("validate"), ",") {
+		parts := strings.SplitN(strings.TrimSpace(rule), "=", 2)
+		value := ""
+		if len(parts) > 1 {
+			value = parts[1]
+		}
+		switch parts[0] {
+		case "required":
+			required = true
+		case "min", "max", "len":
+			s.limit(parts[0], value)
+		case "oneof":
+			s.Enum = nil
+			for _, v := range strings.Split(value, "|") {
+				s.Enum = append(s.Enum, s.value(v))
+			}
+		}
+	}
+	return required
+}
+
+//limit sets the min/max value of numbers or size of strings, arrays and objects
+func (s *Schema) limit(rule string, value string) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return
+	}
+	n := int(f)
+	var min, max **int
+	switch s.Type {
+	case "integer", "number":
+		if rule != "max" {
+			s.Minimum = &f
+		}
+		if rule != "min" {
+			s.Maximum = &f
+		}
+		return
+	case "string":
+		min, max = &s.MinLength, &s.MaxLength
+	case "array":
+		min, max = &s.MinItems, &s.MaxItems
+	case "object":
+		min, max = &s.MinProperties, &s.MaxProperties
+	default:
+		return
+	}
+	if rule != "max" {
+		*min = &n
+	}
+	if rule != "min" {
+		*max = &n
+	}
+}
+
+//value converts a value from a tag to the type of the schema
+func (s *Schema) value(text string) interface{} {
+	switch s.Type {
+	case "integer", "number":
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return f
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(text); err == nil {
+			return b
+		}
+	}
+	return text
+}
+
+//plain is Schema without its JSON methods
+type plain Schema
+
+//MarshalJSON encodes Nullable as a list of types
+func (s Schema) MarshalJSON() ([]byte, error) {
+	if !s.Nullable {
+		return json.Marshal(plain(s))
+	}
+	return json.Marshal(struct {
+		plain
+		Type []string `json:"type"`
+	}{plain: plain(s), Type: []string{s.Type, "null"}})
+}
+
+//UnmarshalJSON decodes a type or list of types with "null"
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	var v struct {
+		plain
+		Type json.RawMessage `json:"type"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*s = Schema(v.plain)
+	if len(v.Type) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(v.Type, &s.Type); err == nil {
+		return nil
+	}
+	var types []string
+	if err := json.Unmarshal(v.Type, &types); err != nil {
+		return err
+	}
+	for _, t := range types {
+		if t == "null" {
+			s.Nullable = true
+		} else {
+			s.Type = t
+		}
+	}
+	return nil
+}
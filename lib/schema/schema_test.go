@@ -0,0 +1,118 @@
+package schema
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jansemmelink/msf/lib/log/level"
+)
+
+type base struct {
+	ID string `json:"id" validate:"required,len=8" pattern:"^[a-z0-9]+$" doc:"Unique ID."`
+}
+
+type node struct {
+	Name     string  `json:"name"`
+	Children []*node `json:"children"`
+}
+
+type sample struct {
+	base
+	Level   level.Enum         `json:"level" default:"info"`
+	Count   int                `json:"count" validate:"min=1,max=10" default:"5"`
+	Color   string             `json:"color,omitempty" validate:"oneof=red|green"`
+	Tags    []string           `json:"tags" validate:"max=3"`
+	Attrs   map[string]float64 `json:"attrs" validate:"min=1"`
+	When    *time.Time         `json:"when"`
+	Any     interface{}        `json:"any"`
+	Tree    node               `json:"tree"`
+	Raw     json.RawMessage    `json:"raw"`
+	IP      net.IP             `json:"ip"`
+	Skipped string             `json:"-"`
+	private int
+}
+
+func TestOf(t *testing.T) {
+	s := For(sample{})
+	if s.Type != "object" || len(s.Properties) != 11 || strings.Join(s.Required, ",") != "id" {
+		t.Fatalf("wrong object: %+v", s)
+	}
+	id := s.Properties["id"]
+	if id.Type != "string" || *id.MinLength != 8 || *id.MaxLength != 8 || id.Pattern == "" || id.Description != "Unique ID." {
+		t.Fatalf("wrong id: %+v", id)
+	}
+	if l := s.Properties["level"]; l.Type != "string" || len(l.Enum) != 8 || l.Enum[5] != "info" || l.Default != "info" {
+		t.Fatalf("wrong level: %+v", l)
+	}
+	if c := s.Properties["count"]; c.Type != "integer" || *c.Minimum != 1 || *c.Maximum != 10 || c.Default != 5.0 {
+		t.Fatalf("wrong count: %+v", c)
+	}
+	if c := s.Properties["color"]; len(c.Enum) != 2 || c.Enum[1] != "green" {
+		t.Fatalf("wrong color: %+v", c)
+	}
+	if tags := s.Properties["tags"]; tags.Type != "array" || tags.Items.Type != "string" || *tags.MaxItems != 3 || tags.MinItems != nil {
+		t.Fatalf("wrong tags: %+v", tags)
+	}
+	if a := s.Properties["attrs"]; a.Type != "object" || a.AdditionalProperties.Type != "number" || *a.MinProperties != 1 {
+		t.Fatalf("wrong attrs: %+v", a)
+	}
+	if w := s.Properties["when"]; w.Type != "string" || w.Format != "date-time" || !w.Nullable {
+		t.Fatalf("wrong when: %+v", w)
+	}
+	//custom JSON may be any value, custom text is a string
+	if raw := s.Properties["raw"]; raw.Type != "" || raw.Properties != nil {
+		t.Fatalf("wrong raw: %+v", raw)
+	}
+	if ip := s.Properties["ip"]; ip.Type != "string" {
+		t.Fatalf("wrong ip: %+v", ip)
+	}
+	children := s.Properties["tree"].Properties["children"]
+	if children.Type != "array" || children.Items.Type != "object" || children.Items.Properties != nil {
+		t.Fatalf("wrong recursion: %+v", children.Items)
+	}
+	if For(nil) != nil {
+		t.Fatalf("schema for nil")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	data, err := json.Marshal(For(struct {
+		When *time.Time `json:"when"`
+	}{}))
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	if !strings.Contains(string(data), `"when":{"format":"date-time","type":["string","null"]}`) {
+		t.Fatalf("wrong JSON: %s", data)
+	}
+	s := &Schema{}
+	if err := json.Unmarshal(data, s); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if w := s.Properties["when"]; w.Type != "string" || !w.Nullable || s.Type != "object" {
+		t.Fatalf("wrong schema: %+v", w)
+	}
+}
+
+func TestCustomJSON(t *testing.T) {
+	for _, c := range []struct {
+		v                        interface{}
+		time, enum, opaque, text bool
+	}{
+		{time.Time{}, true, false, false, false},
+		{level.Enum(0), false, true, false, false},
+		{json.RawMessage{}, false, false, true, false},
+		{net.IP{}, false, false, false, true},
+		{&net.IP{}, false, false, false, false},
+		{"", false, false, false, false},
+	} {
+		ty := reflect.TypeOf(c.v)
+		if IsTime(ty) != c.time || IsEnum(ty) != c.enum || IsOpaque(ty) != c.opaque || IsText(ty) != c.text {
+			t.Errorf("%T: wrong classification", c.v)
+		}
+	}
+}